@@ -0,0 +1,249 @@
+package global
+
+import (
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+	"github.com/snowie2000/livetv/util"
+)
+
+// PersistentJar is a named cookie jar saved to the database.
+// Matching is done by net/http/cookiejar, a copy of every cookie is kept beside it because the standard jar can't be listed.
+type PersistentJar struct {
+	name    string
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[string]*http.Cookie // domain;path;name => cookie
+	save    *time.Timer
+}
+
+const cookieSaveDelay = 5 * time.Second
+
+var cookieJars syncx.Map[string, *PersistentJar]
+
+func newPersistentJar(name string) *PersistentJar {
+	jar, _ := cookiejar.New(nil)
+	return &PersistentJar{
+		name:    name,
+		jar:     jar,
+		cookies: make(map[string]*http.Cookie),
+	}
+}
+
+// GetCookieJar returns the jar with the given name, loading it from the database on first use
+func GetCookieJar(name string) *PersistentJar {
+	if j, ok := cookieJars.Load(name); ok {
+		return j
+	}
+	j := newPersistentJar(name)
+	var rec model.CookieJar
+	if DB != nil && DB.Where("name = ?", name).First(&rec).Error == nil {
+		if cookies, err := util.ParseCookiesTxt(strings.NewReader(rec.Cookies)); err == nil {
+			j.add(cookies)
+		} else {
+			log.Println("failed to load cookie jar", name, err)
+		}
+	}
+	j, _ = cookieJars.LoadOrStore(name, j)
+	return j
+}
+
+// CookieJarNames lists the jars in memory and in the database
+func CookieJarNames() []string {
+	names := make(map[string]bool)
+	var recs []model.CookieJar
+	if DB != nil {
+		DB.Select("name").Find(&recs)
+	}
+	for _, rec := range recs {
+		names[rec.Name] = true
+	}
+	cookieJars.Range(func(name string, j *PersistentJar) bool {
+		names[name] = true
+		return true
+	})
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// DeleteCookieJar drops a jar from memory and the database
+func DeleteCookieJar(name string) error {
+	if j, ok := cookieJars.LoadAndDelete(name); ok {
+		j.mu.Lock()
+		if j.save != nil {
+			j.save.Stop()
+		}
+		j.mu.Unlock()
+	}
+	if DB == nil {
+		return nil
+	}
+	return DB.Delete(model.CookieJar{}, "name = ?", name).Error
+}
+
+// FlushCookieJars saves pending changes of all jars immediately
+func FlushCookieJars() {
+	cookieJars.Range(func(name string, j *PersistentJar) bool {
+		j.mu.Lock()
+		pending := j.save != nil && j.save.Stop()
+		j.mu.Unlock()
+		if pending {
+			j.persist()
+		}
+		return true
+	})
+}
+
+// the path a cookie defaults to when it has no path attribute, see RFC 6265 5.1.4
+func defaultCookiePath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" || p[0] != '/' {
+		return "/"
+	}
+	if i := strings.LastIndex(p, "/"); i > 0 {
+		return p[:i]
+	}
+	return "/"
+}
+
+func cookieKey(c *http.Cookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// record a copy of a cookie accepted from u, must be called with j.mu held
+func (j *PersistentJar) record(u *url.URL, c *http.Cookie) {
+	saved := &http.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Domain:   strings.ToLower(u.Hostname()), // host-only
+		Path:     c.Path,
+		Expires:  c.Expires,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+	}
+	if c.Domain != "" {
+		saved.Domain = "." + strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	}
+	if saved.Path == "" || saved.Path[0] != '/' {
+		saved.Path = defaultCookiePath(u)
+	}
+	switch {
+	case c.MaxAge < 0:
+		saved.Expires = time.Unix(1, 0)
+	case c.MaxAge > 0:
+		saved.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+	}
+	if !saved.Expires.IsZero() && saved.Expires.Before(time.Now()) {
+		delete(j.cookies, cookieKey(saved))
+		return
+	}
+	j.cookies[cookieKey(saved)] = saved
+}
+
+func (j *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jar.SetCookies(u, cookies)
+	for _, c := range cookies {
+		j.record(u, c)
+	}
+	j.scheduleSave()
+}
+
+func (j *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	jar := j.jar // replaced by Import
+	j.mu.Unlock()
+	return jar.Cookies(u)
+}
+
+// List returns the unexpired cookies of the jar
+func (j *PersistentJar) List() []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	list := make([]*http.Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if c.Expires.IsZero() || c.Expires.After(now) {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return cookieKey(list[a]) < cookieKey(list[b])
+	})
+	return list
+}
+
+// Export returns the cookies in the netscape cookies.txt format
+func (j *PersistentJar) Export() string {
+	return util.FormatCookiesTxt(j.List())
+}
+
+// Import adds cookies in the netscape cookies.txt format to the jar, replacing all existing cookies if replace is set
+func (j *PersistentJar) Import(text string, replace bool) (int, error) {
+	cookies, err := util.ParseCookiesTxt(strings.NewReader(text))
+	if err != nil {
+		return 0, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if replace {
+		j.jar, _ = cookiejar.New(nil)
+		j.cookies = make(map[string]*http.Cookie)
+	}
+	j.add(cookies)
+	j.scheduleSave()
+	return len(cookies), nil
+}
+
+// replay cookies read from a cookies.txt as if the sites had set them, must be called with j.mu held
+func (j *PersistentJar) add(cookies []*http.Cookie) {
+	for _, c := range cookies {
+		u := &url.URL{Scheme: "http", Host: strings.TrimPrefix(c.Domain, "."), Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		set := *c
+		if strings.HasPrefix(c.Domain, ".") {
+			set.Domain = u.Host
+		} else {
+			set.Domain = ""
+		}
+		j.jar.SetCookies(u, []*http.Cookie{&set})
+		j.record(u, &set)
+	}
+}
+
+// cookies are usually set in bursts, save them a moment later. Must be called with j.mu held
+func (j *PersistentJar) scheduleSave() {
+	if j.save != nil {
+		j.save.Stop()
+	}
+	j.save = time.AfterFunc(cookieSaveDelay, j.persist)
+}
+
+func (j *PersistentJar) persist() {
+	if DB == nil {
+		return
+	}
+	if current, ok := cookieJars.Load(j.name); !ok || current != j {
+		return // deleted in the meantime
+	}
+	var rec model.CookieJar
+	err := DB.Where(model.CookieJar{Name: j.name}).Assign(model.CookieJar{Cookies: j.Export()}).FirstOrCreate(&rec).Error
+	if err != nil {
+		log.Println("failed to save cookie jar", j.name, err)
+	}
+}
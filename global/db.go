@@ -1,6 +1,9 @@
 package global
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/jinzhu/gorm"
 	"github.com/snowie2000/livetv/model"
 	_ "modernc.org/sqlite"
@@ -13,17 +16,41 @@ func InitDB(filepath string) (err error) {
 	if err != nil {
 		return err
 	}
-	err = DB.AutoMigrate(&model.Config{}, &model.Channel{}).Error
+	if err = migrate(filepath); err != nil {
+		DB.Close()
+		return err
+	}
+	return loadConfigs()
+}
+
+// OpenDB opens an existing database read only and without migrating it, it must already be at SchemaVersion
+func OpenDB(filepath string) (err error) {
+	if _, err = os.Stat(filepath); err != nil {
+		return err
+	}
+	DB, err = gorm.Open("sqlite", "file:"+filepath+"?mode=ro")
 	if err != nil {
 		return err
 	}
-	// update old parsers to their new names
-	DB.Model(&model.Channel{}).Where("parser IN (?)", []string{"httpRedirect", "direct"}).Update("parser", "http")
+	version := 0
+	if DB.HasTable(&model.SchemaVersion{}) {
+		if version, err = currentSchemaVersion(); err != nil {
+			DB.Close()
+			return err
+		}
+	}
+	if version != SchemaVersion() {
+		DB.Close()
+		return fmt.Errorf("database schema version %d is not the one of this livetv (%d), start livetv once to migrate it", version, SchemaVersion())
+	}
+	return loadConfigs()
+}
 
-	// set default value for configs
+// set default value for configs
+func loadConfigs() error {
 	for key, valueDefault := range defaultConfigValue {
 		var valueInDB model.Config
-		err = DB.Where("name = ?", key).First(&valueInDB).Error
+		err := DB.Where("name = ?", key).First(&valueInDB).Error
 		if err != nil {
 			if gorm.IsRecordNotFoundError(err) {
 				ConfigCache.Store(key, valueDefault)
@@ -12,12 +12,23 @@ import (
 
 var defaultConfigValue = map[string]string{
 	"ytdl_cmd":  "yt-dlp",
-	"ytdl_args": "--extractor-args youtube:skip=dash -f b -g {url}",
+	"ytdl_args": "--extractor-args youtube:skip=dash -f b -J {url}",
 	"base_url":  "http://127.0.0.1:9000",
 	"password":  "password",
 	"apiKey":    "",
+
+	"youtube_quota": "10000", // daily units of the data api
+
+	"refresh_schedule": "@every 3h",
+	"parse_workers":    "4",
+
+	"webhook_debounce": "60",
+
+	"offline_slate": "", // shown while a channel is offline: empty or none for a 404, builtin or an uploaded slate
 }
 
+const DefaultUserAgent string = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
+
 var (
 	HttpClientTimeout = 10 * time.Second
 	CookieJar, _      = cookiejar.New(nil)
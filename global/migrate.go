@@ -0,0 +1,187 @@
+package global
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/snowie2000/livetv/model"
+)
+
+type migration struct {
+	name string
+	up   func(tx *gorm.DB) error
+}
+
+// migrations in the order they are applied, the version of a migration is its position starting at 1.
+// Never edit or reorder the ones that have been released, append a new one instead.
+// A migration works on the schema of its own time, so it must not use the structs of the model package, they change later.
+var migrations = []migration{
+	{"initial schema", func(tx *gorm.DB) error {
+		// databases from before versioning already have most of it, AutoMigrate only adds what's missing
+		return tx.AutoMigrate(&v1Config{}, &v1Channel{}, &v1Webhook{}, &v1Script{}, &v1ExecParser{}, &v1CookieJar{}, &v1LiveCache{}).Error
+	}},
+	{"rename old parsers", func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE channels SET parser = ? WHERE parser IN (?)", "http", []string{"httpRedirect", "direct"}).Error
+	}},
+	{"channels managed by the config file", func(tx *gorm.DB) error {
+		return tx.Exec("ALTER TABLE channels ADD COLUMN managed bool DEFAULT 0").Error
+	}},
+}
+
+// the schema of version 1
+
+type v1Config struct {
+	Name string `gorm:"primary_key"`
+	Data string
+}
+
+func (v1Config) TableName() string { return "configs" }
+
+type v1Channel struct {
+	ID            int `gorm:"primary_key"`
+	Name          string
+	Logo          string
+	URL           string
+	Parser        string
+	Proxy         bool
+	TsProxy       string
+	Decrypt       bool
+	ProxyUrl      string
+	Category      string `gorm:"index"`
+	HasSubChannel bool
+	Schedule      string
+	Timeout       int
+	ParserConfig  string
+	UserAgent     string
+	Referer       string
+	Origin        string
+	Cookie        string
+	Headers       string
+	CookieJar     string
+	Quality       string
+	AudioLang     string
+	SubsLang      string
+	Slate         string
+	AdFilter      string
+}
+
+func (v1Channel) TableName() string { return "channels" }
+
+type v1Webhook struct {
+	ID       int `gorm:"primary_key"`
+	Name     string
+	URL      string
+	Headers  string
+	Template string
+	Events   string
+	Enabled  bool
+}
+
+func (v1Webhook) TableName() string { return "webhooks" }
+
+type v1Script struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"`
+	Code    string
+	Enabled bool
+}
+
+func (v1Script) TableName() string { return "scripts" }
+
+type v1ExecParser struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"`
+	Command string
+	Args    string
+	Timeout int
+	Enabled bool
+}
+
+func (v1ExecParser) TableName() string { return "exec_parsers" }
+
+type v1CookieJar struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"`
+	Cookies string
+}
+
+func (v1CookieJar) TableName() string { return "cookie_jars" }
+
+type v1LiveCache struct {
+	URL        string `gorm:"primary_key"`
+	LiveUrl    string
+	Logo       string
+	ExtraInfo  string
+	Title      string
+	ExpiresAt  time.Time
+	ResolvedAt time.Time
+}
+
+func (v1LiveCache) TableName() string { return "live_caches" }
+
+// SchemaVersion is the database version this build expects
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+func currentSchemaVersion() (int, error) {
+	var v model.SchemaVersion
+	err := DB.Order("version desc").First(&v).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+	return v.Version, err
+}
+
+// copy the database before it's changed, VACUUM INTO takes a consistent snapshot of an open database
+func backupDB(filepath string, version int) error {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", filepath, version, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return fmt.Errorf("backup %s already exists", backup)
+	}
+	if err := DB.Exec("VACUUM INTO ?", backup).Error; err != nil {
+		return err
+	}
+	log.Println("database backed up to", backup)
+	return nil
+}
+
+// migrate brings the database at filepath up to the version of this build, each migration runs in a transaction of its own
+func migrate(filepath string) error {
+	if err := DB.AutoMigrate(&model.SchemaVersion{}).Error; err != nil {
+		return err
+	}
+	version, err := currentSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this livetv supports (%d), upgrade livetv or restore a backup", version, SchemaVersion())
+	}
+	if version == SchemaVersion() {
+		return nil
+	}
+	// a new database has nothing to lose
+	if version > 0 || DB.HasTable(&model.Channel{}) {
+		if err := backupDB(filepath, version); err != nil {
+			return fmt.Errorf("database backup failed, not migrating: %w", err)
+		}
+	}
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		log.Printf("migrating database to version %d: %s\n", i+1, m.name)
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&model.SchemaVersion{Version: i + 1, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", i+1, m.name, err)
+		}
+	}
+	return nil
+}
@@ -115,6 +115,17 @@ func TransportWithProxy(proxyUrl string) *http.Transport {
 	return tr
 }
 
+// ParseHeaderLines parses "Key: Value" lines, blank or malformed lines are skipped
+func ParseHeaderLines(text string) http.Header {
+	header := make(http.Header)
+	for _, line := range strings.Split(text, "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(k) != "" {
+			header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	return header
+}
+
 func CloseBody(resp any) {
 	if resp, ok := resp.(*http.Response); ok {
 		if resp != nil && resp.Body != nil {
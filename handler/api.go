@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
@@ -184,6 +185,19 @@ func loadConfig() (Config, error) {
 	if apiKey, err := global.GetConfig("apiKey"); err == nil {
 		conf.ApiKey = apiKey
 	}
+	if debounce, err := global.GetConfig("webhook_debounce"); err == nil {
+		conf.Debounce = debounce
+	}
+	if schedule, err := global.GetConfig("refresh_schedule"); err == nil {
+		conf.Schedule = schedule
+	}
+	if quota, err := global.GetConfig("youtube_quota"); err == nil {
+		conf.Quota = quota
+	}
+	if slate, err := global.GetConfig("offline_slate"); err == nil {
+		conf.Slate = slate
+	}
+	conf.QuotaUsed, _ = plugin.YoutubeQuotaUsed()
 	return conf, nil
 }
 
@@ -212,6 +226,14 @@ func PluginListHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, list)
 }
 
+// title of the current program reported by the parser
+func liveTitle(url string) string {
+	if info, ok := global.URLCache.Load(url); ok {
+		return info.Title
+	}
+	return ""
+}
+
 func ChannelListHandler(c *gin.Context) {
 	if sessions.Default(c).Get("logined") != true {
 		c.String(http.StatusUnauthorized, "Unauthorized")
@@ -238,6 +260,10 @@ func ChannelListHandler(c *gin.Context) {
 	}
 	for i, v := range channelModels {
 		status := service.GetStatus(v.URL)
+		nextUpdate := ""
+		if next := service.NextRefresh(v.ID); !next.IsZero() {
+			nextUpdate = next.Format("2006-01-02 15:04:05")
+		}
 		ch := Channel{
 			ID:         v.ChannelID,
 			Name:       v.Name,
@@ -246,11 +272,29 @@ func ChannelListHandler(c *gin.Context) {
 			TsProxy:    v.TsProxy,
 			M3U8:       fmt.Sprintf("%s/live.m3u8?token=%s&c=%d", baseUrl, v.Token, v.ID),
 			Proxy:      v.Proxy,
+			Decrypt:    v.Decrypt,
 			ProxyUrl:   v.ProxyUrl,
 			LastUpdate: status.Time.Format("2006-01-02 15:04:05"),
 			Status:     status.Status,
 			Message:    status.Msg,
+			Title:      liveTitle(v.URL),
 			Category:   v.Category,
+			Schedule:   v.Schedule,
+			Timeout:    v.Timeout,
+			Config:     v.ParserConfig,
+			UserAgent:  v.UserAgent,
+			Referer:    v.Referer,
+			Origin:     v.Origin,
+			Cookie:     v.Cookie,
+			Headers:    v.Headers,
+			CookieJar:  v.CookieJar,
+			Quality:    v.Quality,
+			AudioLang:  v.AudioLang,
+			SubsLang:   v.SubsLang,
+			Slate:      v.Slate,
+			AdFilter:   v.AdFilter,
+			Managed:    v.Managed,
+			NextUpdate: nextUpdate,
 		}
 		if len(v.Children) > 0 {
 			list := []Channel{}
@@ -265,12 +309,15 @@ func ChannelListHandler(c *gin.Context) {
 					TsProxy:    sub.TsProxy,
 					M3U8:       fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, sub.Token, subID),
 					Proxy:      sub.Proxy,
+					Decrypt:    sub.Decrypt,
 					ProxyUrl:   sub.ProxyUrl,
 					LastUpdate: status.Time.Format("2006-01-02 15:04:05"),
 					Status:     status.Status,
 					Message:    status.Msg,
+					Title:      liveTitle(sub.URL),
 					Category:   sub.Category,
-					Virtual:    true, // sub channels are all virtual
+					NextUpdate: nextUpdate, // sub channels are refreshed along with their parent
+					Virtual:    true,       // sub channels are all virtual
 				}
 				list = append(list, c)
 			}
@@ -281,6 +328,16 @@ func ChannelListHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, channels)
 }
 
+// let the parser check its per-channel config
+func validateParserConfig(parser string, config string) error {
+	if p, err := plugin.GetPlugin(parser); err == nil {
+		if validator, ok := p.(plugin.ConfigValidator); ok {
+			return validator.ValidateConfig(config)
+		}
+	}
+	return nil
+}
+
 func NewChannelHandler(c *gin.Context) {
 	if sessions.Default(c).Get("logined") != true {
 		c.String(http.StatusUnauthorized, "Unauthorized")
@@ -292,19 +349,71 @@ func NewChannelHandler(c *gin.Context) {
 	chProxyUrl := c.PostForm("proxyurl")
 	chTsProxy := c.PostForm("tsproxy")
 	chCategory := c.PostForm("category")
+	chSchedule := strings.TrimSpace(c.PostForm("schedule"))
+	chTimeout, _ := strconv.Atoi(c.PostForm("timeout"))
+	chConfig := c.PostForm("parserconfig")
+	chUserAgent := strings.TrimSpace(c.PostForm("useragent"))
+	chReferer := strings.TrimSpace(c.PostForm("referer"))
+	chOrigin := strings.TrimSpace(c.PostForm("origin"))
+	chCookie := strings.TrimSpace(c.PostForm("cookie"))
+	chHeaders := c.PostForm("headers")
+	chCookieJar := strings.TrimSpace(c.PostForm("cookiejar"))
+	chQuality := strings.TrimSpace(c.PostForm("quality"))
+	chAudioLang := strings.TrimSpace(c.PostForm("audiolang"))
+	chSubsLang := strings.TrimSpace(c.PostForm("subslang"))
+	chSlate := strings.TrimSpace(c.PostForm("slate"))
+	chAdFilter := strings.TrimSpace(c.PostForm("adfilter"))
 	if chName == "" || chURL == "" {
 		c.String(http.StatusBadRequest, "Incomplete channel info")
 		return
 	}
+	if chSchedule != "" {
+		if _, err := service.ParseSchedule(chSchedule); err != nil {
+			c.String(http.StatusBadRequest, "Invalid schedule: %s", err.Error())
+			return
+		}
+	}
+	if err := validateParserConfig(chParser, chConfig); err != nil {
+		c.String(http.StatusBadRequest, "Invalid parser config: %s", err.Error())
+		return
+	}
+	if _, err := plugin.ParseQuality(chQuality); err != nil {
+		c.String(http.StatusBadRequest, "Invalid quality: %s", err.Error())
+		return
+	}
+	if err := service.CheckSlate(chSlate); err != nil {
+		c.String(http.StatusBadRequest, "Invalid slate: %s", err.Error())
+		return
+	}
+	if _, err := service.ParseAdFilter(chAdFilter); err != nil {
+		c.String(http.StatusBadRequest, "Invalid ad filter: %s", err.Error())
+		return
+	}
 	chProxy := c.PostForm("proxy") == "true"
+	chDecrypt := c.PostForm("decrypt") == "true"
 	mch := &model.Channel{
 		Name:          chName,
 		URL:           chURL,
 		Proxy:         chProxy,
+		Decrypt:       chDecrypt,
 		ProxyUrl:      chProxyUrl,
 		Parser:        chParser,
 		TsProxy:       chTsProxy,
 		Category:      chCategory,
+		Schedule:      chSchedule,
+		Timeout:       chTimeout,
+		ParserConfig:  chConfig,
+		UserAgent:     chUserAgent,
+		Referer:       chReferer,
+		Origin:        chOrigin,
+		Cookie:        chCookie,
+		Headers:       chHeaders,
+		CookieJar:     chCookieJar,
+		Quality:       chQuality,
+		AudioLang:     chAudioLang,
+		SubsLang:      chSubsLang,
+		Slate:         chSlate,
+		AdFilter:      chAdFilter,
 		HasSubChannel: false,
 	}
 	// check if the parser can provide sub channels
@@ -350,24 +459,80 @@ func UpdateChannelHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
+	if channel.Managed {
+		c.String(http.StatusForbidden, "channel is managed by the config file")
+		return
+	}
 	chName := c.PostForm("name")
 	chURL := c.PostForm("url")
 	chParser := c.PostForm("parser")
 	chProxyUrl := c.PostForm("proxyurl")
 	chTsProxy := c.PostForm("tsproxy")
 	chCategory := c.PostForm("category")
+	chSchedule := strings.TrimSpace(c.PostForm("schedule"))
+	chTimeout, _ := strconv.Atoi(c.PostForm("timeout"))
+	chConfig := c.PostForm("parserconfig")
+	chUserAgent := strings.TrimSpace(c.PostForm("useragent"))
+	chReferer := strings.TrimSpace(c.PostForm("referer"))
+	chOrigin := strings.TrimSpace(c.PostForm("origin"))
+	chCookie := strings.TrimSpace(c.PostForm("cookie"))
+	chHeaders := c.PostForm("headers")
+	chCookieJar := strings.TrimSpace(c.PostForm("cookiejar"))
+	chQuality := strings.TrimSpace(c.PostForm("quality"))
+	chAudioLang := strings.TrimSpace(c.PostForm("audiolang"))
+	chSubsLang := strings.TrimSpace(c.PostForm("subslang"))
+	chSlate := strings.TrimSpace(c.PostForm("slate"))
+	chAdFilter := strings.TrimSpace(c.PostForm("adfilter"))
 	if chName == "" || chURL == "" {
 		c.String(http.StatusBadRequest, "Incomplete channel info")
 		return
 	}
+	if chSchedule != "" {
+		if _, err := service.ParseSchedule(chSchedule); err != nil {
+			c.String(http.StatusBadRequest, "Invalid schedule: %s", err.Error())
+			return
+		}
+	}
+	if err := validateParserConfig(chParser, chConfig); err != nil {
+		c.String(http.StatusBadRequest, "Invalid parser config: %s", err.Error())
+		return
+	}
+	if _, err := plugin.ParseQuality(chQuality); err != nil {
+		c.String(http.StatusBadRequest, "Invalid quality: %s", err.Error())
+		return
+	}
+	if err := service.CheckSlate(chSlate); err != nil {
+		c.String(http.StatusBadRequest, "Invalid slate: %s", err.Error())
+		return
+	}
+	if _, err := service.ParseAdFilter(chAdFilter); err != nil {
+		c.String(http.StatusBadRequest, "Invalid ad filter: %s", err.Error())
+		return
+	}
 	chProxy := c.PostForm("proxy") == "true"
+	chDecrypt := c.PostForm("decrypt") == "true"
 	channel.Name = chName
 	channel.Parser = chParser
 	channel.Proxy = chProxy
+	channel.Decrypt = chDecrypt
 	channel.ProxyUrl = chProxyUrl
 	channel.URL = chURL
 	channel.TsProxy = chTsProxy
 	channel.Category = chCategory
+	channel.Schedule = chSchedule
+	channel.Timeout = chTimeout
+	channel.ParserConfig = chConfig
+	channel.UserAgent = chUserAgent
+	channel.Referer = chReferer
+	channel.Origin = chOrigin
+	channel.Cookie = chCookie
+	channel.Headers = chHeaders
+	channel.CookieJar = chCookieJar
+	channel.Quality = chQuality
+	channel.AudioLang = chAudioLang
+	channel.SubsLang = chSubsLang
+	channel.Slate = chSlate
+	channel.AdFilter = chAdFilter
 	channel.HasSubChannel = false
 
 	// check if the parser can provide sub channels
@@ -400,6 +565,10 @@ func DeleteChannelHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, "can't delete sub channels")
 		return
 	}
+	if channel, err := service.GetChannel(chID, -1); err == nil && channel.Managed {
+		c.String(http.StatusForbidden, "channel is managed by the config file")
+		return
+	}
 	err := service.DeleteChannel(chID)
 	if err != nil {
 		log.Println(err.Error())
@@ -442,6 +611,9 @@ func UpdateConfigHandler(c *gin.Context) {
 	baseUrl := strings.TrimSuffix(c.PostForm("baseurl"), "/")
 	apiKey := strings.TrimSpace(c.PostForm("apikey"))
 	secret := strings.TrimSpace(c.PostForm("secret"))
+	debounce := strings.TrimSpace(c.PostForm("debounce"))
+	schedule := strings.TrimSpace(c.PostForm("schedule"))
+	quota := strings.TrimSpace(c.PostForm("quota"))
 	if len(ytdlCmd) > 0 {
 		err := global.SetConfig("ytdl_cmd", ytdlCmd)
 		if err != nil {
@@ -466,6 +638,57 @@ func UpdateConfigHandler(c *gin.Context) {
 			return
 		}
 	}
+	if len(debounce) > 0 {
+		if n, err := strconv.Atoi(debounce); err != nil || n < 0 {
+			c.String(http.StatusBadRequest, "Invalid debounce interval")
+			return
+		}
+		err := global.SetConfig("webhook_debounce", debounce)
+		if err != nil {
+			log.Println(err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if len(schedule) > 0 {
+		if _, err := service.ParseSchedule(schedule); err != nil {
+			c.String(http.StatusBadRequest, "Invalid schedule: %s", err.Error())
+			return
+		}
+		err := global.SetConfig("refresh_schedule", schedule)
+		if err != nil {
+			log.Println(err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		service.RescheduleDefaultChannels()
+	}
+	if len(quota) > 0 {
+		if _, err := strconv.Atoi(quota); err != nil {
+			c.String(http.StatusBadRequest, "Invalid youtube quota")
+			return
+		}
+		err := global.SetConfig("youtube_quota", quota)
+		if err != nil {
+			log.Println(err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	// empty turns the slate off, so only forms that have the field change it
+	if slate, ok := c.GetPostForm("slate"); ok {
+		slate = strings.TrimSpace(slate)
+		if err := service.CheckSlate(slate); err != nil {
+			c.String(http.StatusBadRequest, "Invalid slate: %s", err.Error())
+			return
+		}
+		err := global.SetConfig("offline_slate", slate)
+		if err != nil {
+			log.Println(err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 	global.SetConfig("apiKey", apiKey)
 	global.SetConfig("secret", secret)
 	global.ClearSecretToken()
@@ -511,7 +734,7 @@ func LoginActionHandler(c *gin.Context) {
 	// verify captcha before verifying password so as to protect us from bruteforce attack.
 	captchaId := c.PostForm("captcha_id")
 	captchaAnswer := c.PostForm("answer")
-	if !recaptcha.DefaultCaptcha.Verify(&recaptcha.CaptchaData{captchaId, "", captchaAnswer}) {
+	if !recaptcha.DefaultCaptcha.Verify(&recaptcha.CaptchaData{CaptchaId: captchaId, Answer: captchaAnswer}) {
 		c.String(http.StatusForbidden, "Invalid captcha")
 		return
 	}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/service"
+)
+
+func CookieJarListHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	c.JSON(http.StatusOK, service.GetCookieJars())
+}
+
+func ImportCookiesHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	cookies := c.PostForm("cookies")
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		defer f.Close()
+		var sb strings.Builder
+		if _, err := io.Copy(&sb, io.LimitReader(f, 10*1024*1024)); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cookies = sb.String()
+	}
+	if name == "" {
+		c.String(http.StatusBadRequest, "empty name")
+		return
+	}
+	count, err := service.ImportCookies(name, cookies, c.PostForm("replace") == "true")
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imported": count})
+}
+
+func ExportCookiesHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		c.String(http.StatusBadRequest, "empty name")
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="cookies.txt"`)
+	c.Data(http.StatusOK, "text/plain; charset=UTF-8", []byte(service.ExportCookies(name)))
+}
+
+func DeleteCookieJarHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		c.String(http.StatusBadRequest, "empty name")
+		return
+	}
+	err := service.DeleteCookieJar(name)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func ExecParserListHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	parsers, err := service.GetAllExecParsers()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, "error: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, parsers)
+}
+
+func saveExecParserFromForm(c *gin.Context, p *model.ExecParser) {
+	p.Name = c.PostForm("name")
+	p.Command = c.PostForm("cmd")
+	p.Args = c.PostForm("args")
+	p.Timeout, _ = strconv.Atoi(c.PostForm("timeout"))
+	p.Enabled = c.PostForm("enabled") != "false"
+	if p.Name == "" || p.Command == "" {
+		c.String(http.StatusBadRequest, "Incomplete parser info")
+		return
+	}
+	err := service.SaveExecParser(p)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func NewExecParserHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	saveExecParserFromForm(c, &model.ExecParser{})
+}
+
+func UpdateExecParserHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.PostForm("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	saveExecParserFromForm(c, &model.ExecParser{ID: id})
+}
+
+func DeleteExecParserHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	err := service.DeleteExecParser(id)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
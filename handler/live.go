@@ -3,7 +3,7 @@ package handler
 import (
 	"bytes"
 	"compress/gzip"
-	"context"
+	"encoding/hex"
 	"io"
 	"log"
 	"net/http"
@@ -35,7 +35,7 @@ func M3UHandler(c *gin.Context) {
 		}
 	}
 
-	content, err := service.M3UGenerate()
+	content, err := service.M3UGenerate(service.ProfileQuery(c.Request.URL.Query()))
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -55,7 +55,7 @@ func TXTHandler(c *gin.Context) {
 		}
 	}
 
-	content, err := service.TXTGenerate()
+	content, err := service.TXTGenerate(service.ProfileQuery(c.Request.URL.Query()))
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -132,7 +132,9 @@ func LiveHandler(c *gin.Context) {
 	}
 
 	var m3u8Body string
-	iBody, found := global.M3U8Cache.Get(channelCacheKey)
+	// blocking reloads of low latency hls wait for different parts, they can't share a cached playlist
+	m3u8CacheKey := channelCacheKey + service.BlockingReloadKey(c.Request.URL.Query()) + service.ProfileQuery(c.Request.URL.Query())
+	iBody, found := global.M3U8Cache.Get(m3u8CacheKey)
 	if found {
 		m3u8Body = iBody.(string)
 	} else {
@@ -160,15 +162,19 @@ func LiveHandler(c *gin.Context) {
 		// if proxyUrl == "" {
 		// 	proxyUrl = baseUrl
 		// }
-		liveInfo, err := service.GetLiveM3U8(channelInfo.URL, channelInfo.ProxyUrl, channelInfo.Parser)
+		liveInfo, err := service.GetLiveM3U8(c.Request.Context(), channelInfo)
 		if err != nil {
 			log.Println(err)
-			// return a placeholder video
-			// m3u8Body = service.PlaceHolderHLS() // make a fake m3u8 pointing to the target
+			// loop the offline slate until a reparse succeeds
+			if serveSlate(c, channelInfo, channelCacheKey) {
+				return
+			}
 			c.AbortWithStatus(http.StatusNotFound)
 			c.Writer.WriteString("This channel is not available")
 			return
 		} else {
+			// let feed hosts and forgers know which channel they are serving
+			c.Request = c.Request.WithContext(plugin.WithChannel(c.Request.Context(), channelInfo))
 			parser, err := plugin.GetPlugin(channelInfo.Parser)
 			if err == nil {
 				if handler, ok := parser.(plugin.FeedHost); ok {
@@ -189,26 +195,35 @@ func LiveHandler(c *gin.Context) {
 			)
 			if forger, ok := parser.(plugin.Forger); ok {
 				// if supported, use forged m3u8 playlist
-				finalUrl, bodyString, err = forger.ForgeM3U8(liveInfo)
+				finalUrl, bodyString, err = forger.ForgeM3U8(c.Request.Context(), liveInfo)
 			} else {
 				// the GetM3U8Content will handle health-check, reparse, url decoration etc. and returns the final result and the final url used
-				bodyString, finalUrl, err = service.GetM3U8Content(c, channelInfo.URL, liveInfo.LiveUrl, channelInfo.ProxyUrl, channelInfo.Parser)
+				bodyString, finalUrl, err = service.GetM3U8Content(c, channelInfo, liveInfo.LiveUrl)
 			}
 			if bodyString == "" {
 				log.Println(err)
+				if serveSlate(c, channelInfo, channelCacheKey) {
+					return
+				}
 				c.AbortWithStatus(http.StatusInternalServerError)
 				return
 			}
+			if service.EndSlate(channelCacheKey) {
+				log.Println(channelInfo.URL, "is back, leaving the offline slate")
+			}
 			iTsTransformer, _ := parser.(plugin.TsTransformer)
 			// get m3u8 content and transcode into tsproxy link if needed
-			m3u8Body = service.M3U8Process(finalUrl, bodyString, proxyUrl, global.GetLiveToken(), channelInfo.Proxy, channelNumber,
+			m3u8Body = service.M3U8Process(finalUrl, bodyString, proxyUrl, global.GetLiveToken(), channelInfo.Proxy, channelInfo.Decrypt,
+				service.ChannelRenditionPreference(channelInfo, c.Request.URL.Query()), service.ChannelAdFilter(channelInfo), channelNumber,
 				func(raw string, ts string) string {
 					if iTsTransformer == nil {
 						return ts
 					}
 					return iTsTransformer.TransformTs(raw, ts, liveInfo) // allow plugins to override our default tslink
 				})
-			global.M3U8Cache.Set(channelCacheKey, m3u8Body, 3*time.Second)
+			// a channel back from its slate continues where the slate left off
+			m3u8Body = service.ContinueAfterSlate(channelCacheKey, m3u8Body)
+			global.M3U8Cache.Set(m3u8CacheKey, m3u8Body, 3*time.Second)
 		}
 	}
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -260,10 +275,10 @@ func M3U8ProxyHandler(c *gin.Context) {
 	client := http.Client{
 		Timeout:   global.HttpClientTimeout,
 		Transport: global.TransportWithProxy(channelInfo.ProxyUrl),
-		Jar:       global.CookieJar,
+		Jar:       plugin.ChannelCookieJar(channelInfo),
 	}
-	req, _ := http.NewRequest(http.MethodGet, remoteURL, nil)
-	req.Header.Set("User-Agent", plugin.DefaultUserAgent)
+	req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, remoteURL, nil)
+	req.Header.Set("User-Agent", global.DefaultUserAgent)
 	//req.Header.Set("Accept-Encoding", "gzip")
 	// added possible custom headers
 	queries := c.Request.URL.Query()
@@ -281,6 +296,7 @@ func M3U8ProxyHandler(c *gin.Context) {
 		}
 	}
 	req.URL.RawQuery = reqQueries.Encode()
+	plugin.ApplyChannelHeaders(req, channelInfo)
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Println(err)
@@ -305,12 +321,15 @@ func M3U8ProxyHandler(c *gin.Context) {
 	io.Copy(buffer, reader)
 	// make prefixURL from ourselves
 	// prefixUrl, _ := global.GetConfig("base_url")
-	newList := service.M3U8Process(remoteURL, buffer.String(), "", global.GetLiveToken(), true, chNum, nil)
+	newList := service.M3U8Process(remoteURL, buffer.String(), "", global.GetLiveToken(), true, channelInfo.Decrypt, service.ChannelRenditionPreference(channelInfo, nil), service.ChannelAdFilter(channelInfo), chNum, nil)
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
 	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(newList))
 }
 
+// decrypted segments are held in memory, larger ones are refused
+const maxSegmentSize = 64 * 1024 * 1024
+
 func TsProxyHandler(c *gin.Context) {
 	// verify access token if protection is enabled (by default)
 	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
@@ -352,9 +371,9 @@ func TsProxyHandler(c *gin.Context) {
 	client := http.Client{
 		Timeout:   global.HttpClientTimeout,
 		Transport: global.TransportWithProxy(channelInfo.ProxyUrl),
-		Jar:       global.CookieJar,
+		Jar:       plugin.ChannelCookieJar(channelInfo),
 	}
-	req := c.Request.Clone(context.Background())
+	req := c.Request.Clone(c.Request.Context()) // stop downloading once the client goes away
 	req.RequestURI = ""
 	req.Host = ""
 	req.URL = rurl
@@ -375,12 +394,46 @@ func TsProxyHandler(c *gin.Context) {
 			req.Header.Set(key[6:], value[0])
 		}
 	}
+	plugin.ApplyChannelHeaders(req, channelInfo)
+	// segments of AES-128 streams the client can't decrypt itself
+	var segmentKey, segmentIV []byte
+	if zippedKey := c.Query("key"); zippedKey != "" {
+		keyURL, err := util.DecompressString(zippedKey)
+		if err == nil {
+			segmentIV, err = hex.DecodeString(c.Query("iv"))
+		}
+		if err == nil {
+			segmentKey, err = service.SegmentKey(&client, req, keyURL)
+		}
+		if err != nil {
+			log.Println(err)
+			c.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		req.Header.Del("Accept-Encoding") // we need the raw bytes to decrypt them
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Println(err)
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	if segmentKey != nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent) {
+		defer global.CloseBody(resp)
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxSegmentSize))
+		if err == nil {
+			body, err = util.DecryptAES128(body, segmentKey, segmentIV)
+		}
+		if err != nil {
+			log.Println(remoteURL, err)
+			c.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+		c.Data(http.StatusOK, resp.Header.Get("Content-Type"), body)
+		return
+	}
 	for key, values := range resp.Header {
 		for _, value := range values {
 			c.Writer.Header().Add(key, value)
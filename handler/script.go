@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func ScriptListHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	scripts, err := service.GetAllScripts()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, "error: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, scripts)
+}
+
+func saveScriptFromForm(c *gin.Context, script *model.Script) {
+	script.Name = c.PostForm("name")
+	script.Code = c.PostForm("code")
+	script.Enabled = c.PostForm("enabled") != "false"
+	if script.Name == "" || script.Code == "" {
+		c.String(http.StatusBadRequest, "Incomplete script info")
+		return
+	}
+	err := service.SaveScript(script)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func NewScriptHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	saveScriptFromForm(c, &model.Script{})
+}
+
+func UpdateScriptHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.PostForm("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	saveScriptFromForm(c, &model.Script{ID: id})
+}
+
+func DeleteScriptHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	err := service.DeleteScript(id)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
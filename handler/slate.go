@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+const maxSlateSize = 100 * 1024 * 1024
+
+// serve the offline slate of a channel, false if it has none
+func serveSlate(c *gin.Context, channel *model.Channel, key string) bool {
+	name := service.ChannelSlate(channel)
+	if name == "" {
+		return false
+	}
+	slateUrl := global.MergeUrl(channel.TsProxy, "slate.ts?token="+global.GetLiveToken()+"&name="+name)
+	playlist, err := service.SlatePlaylist(key, name, slateUrl)
+	if err != nil {
+		log.Println("slate", name, "is unavailable:", err)
+		return false
+	}
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+	return true
+}
+
+func SlateHandler(c *gin.Context) {
+	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
+	if !disableProtection && c.Query("token") != global.GetLiveToken() {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	data, _, err := service.LoadSlate(c.Query("name"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Content-Type", "video/mp2t")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(data))
+}
+
+func SlateListHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	c.JSON(http.StatusOK, service.GetSlates())
+}
+
+func UploadSlateHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSlateSize+1))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(data) > maxSlateSize {
+		c.String(http.StatusBadRequest, "slate too large")
+		return
+	}
+	info, err := service.SaveSlate(name, data)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func DeleteSlateHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		c.String(http.StatusBadRequest, "empty name")
+		return
+	}
+	if err := service.DeleteSlate(name); err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func init() {
+	if data, err := webFS.ReadFile("web/placeholder.ts"); err == nil {
+		if err := service.SetBuiltinSlate(data); err != nil {
+			log.Println("builtin slate:", err)
+		}
+	}
+}
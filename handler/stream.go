@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/plugin"
+	"github.com/snowie2000/livetv/service"
+)
+
+// StreamHandler plays a channel as one endless MPEG-TS stream for players that can't do HLS
+func StreamHandler(c *gin.Context) {
+	channelNumber, subNumber := getChannelNumbers(c.Query("c"))
+	if channelNumber <= 0 { // invalid channel id format
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	channelInfo, err := service.GetChannel(channelNumber, subNumber)
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	disableProtection := os.Getenv("LIVETV_FREEACCESS") == "1"
+	if !disableProtection && c.Query("token") != channelInfo.Token {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	c.Request = c.Request.WithContext(plugin.WithChannel(c.Request.Context(), channelInfo))
+
+	// the status is sent with the first segment, so that errors before it can still be reported
+	w := &lazyHeaderWriter{ResponseWriter: c.Writer}
+	err = service.StreamTS(c, channelInfo, w)
+	if err != nil {
+		log.Println(channelInfo.URL, "stream ended:", err)
+		if !w.started {
+			c.String(http.StatusBadGateway, err.Error())
+		}
+	}
+}
+
+type lazyHeaderWriter struct {
+	gin.ResponseWriter
+	started bool
+}
+
+func (w *lazyHeaderWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -7,21 +7,44 @@ type Channel struct {
 	M3U8       string
 	Proxy      bool
 	TsProxy    string
+	Decrypt    bool
 	ProxyUrl   string
 	Parser     string
 	LastUpdate string
 	Status     int
 	Message    string
+	Title      string
 	Category   string
+	Schedule   string
+	Timeout    int
+	Config     string
+	UserAgent  string
+	Referer    string
+	Origin     string
+	Cookie     string
+	Headers    string
+	CookieJar  string
+	Quality    string
+	AudioLang  string
+	SubsLang   string
+	Slate      string
+	AdFilter   string
+	Managed    bool // declared in the config file, can't be edited or deleted
+	NextUpdate string
 	Virtual    bool
 	Children   []Channel `json:"children"`
 }
 
 type Config struct {
-	BaseURL  string `json:"baseurl"`
-	Cmd      string `json:"cmd"`
-	Args     string `json:"args"`
-	ApiKey   string `json:"apikey"`
-	Secret   string `json:"secret"`
-	ProxyURL string `json:"proxyurl"`
+	BaseURL   string `json:"baseurl"`
+	Cmd       string `json:"cmd"`
+	Args      string `json:"args"`
+	ApiKey    string `json:"apikey"`
+	Secret    string `json:"secret"`
+	ProxyURL  string `json:"proxyurl"`
+	Debounce  string `json:"debounce"`
+	Schedule  string `json:"schedule"`
+	Quota     string `json:"quota"`
+	Slate     string `json:"slate"`     // global offline slate, empty for none
+	QuotaUsed int    `json:"quotaused"` // read only
 }
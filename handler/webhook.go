@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/service"
+)
+
+func WebhookListHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	hooks, err := service.GetAllWebhooks()
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, "error: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+func webhookFromForm(c *gin.Context, hook *model.Webhook) bool {
+	hook.Name = c.PostForm("name")
+	hook.URL = c.PostForm("url")
+	hook.Headers = c.PostForm("headers")
+	hook.Template = c.PostForm("template")
+	hook.Events = c.PostForm("events")
+	hook.Enabled = c.PostForm("enabled") != "false"
+	if hook.Name == "" || !global.IsValidURL(hook.URL) {
+		c.String(http.StatusBadRequest, "Incomplete webhook info")
+		return false
+	}
+	return true
+}
+
+func NewWebhookHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	hook := &model.Webhook{}
+	if !webhookFromForm(c, hook) {
+		return
+	}
+	err := service.SaveWebhook(hook)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func UpdateWebhookHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.PostForm("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	hook := &model.Webhook{ID: id}
+	if !webhookFromForm(c, hook) {
+		return
+	}
+	err := service.SaveWebhook(hook)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func DeleteWebhookHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	err := service.DeleteWebhook(id)
+	if err != nil {
+		log.Println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
+
+func TestWebhookHandler(c *gin.Context) {
+	if sessions.Default(c).Get("logined") != true {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, _ := strconv.Atoi(c.Query("id"))
+	if id == 0 {
+		c.String(http.StatusInternalServerError, "empty id")
+		return
+	}
+	err := service.TestWebhook(id)
+	if err != nil {
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
@@ -19,7 +20,6 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/natefinch/lumberjack"
-	"github.com/robfig/cron/v3"
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/route"
 	"github.com/snowie2000/livetv/service"
@@ -29,7 +29,12 @@ func main() {
 	pwd := flag.String("pwd", "", "reset password")
 	listen := flag.String("listen", ":9000", "listening address")
 	disableProtection := flag.Bool("disable-protection", false, "temporarily disable token protection")
+	configFile := flag.String("config", "", "yaml or toml file declaring settings and channels, LIVETV_CONFIG by default")
+	dryRun := flag.Bool("dry-run", false, "print what the config file would change and exit")
 	flag.Parse()
+	if *configFile == "" {
+		*configFile = os.Getenv("LIVETV_CONFIG")
+	}
 	datadir := os.Getenv("LIVETV_DATADIR")
 	if datadir == "" {
 		ex, err := os.Executable()
@@ -56,6 +61,29 @@ func main() {
 		return
 	}
 
+	if *dryRun {
+		if *configFile == "" {
+			log.Fatalln("-dry-run needs a config file")
+		}
+		// the database is only read, scripts are not run
+		err := global.OpenDB(datadir + "/livetv.db")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		service.LoadExecParsers()
+		changes, err := service.ApplyConfigFile(*configFile, true)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("no changes")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		return
+	}
+
 	if *disableProtection {
 		os.Setenv("LIVETV_FREEACCESS", "1")
 	}
@@ -82,14 +110,16 @@ func main() {
 		log.Panicf("init: %s\n", err)
 	}
 	log.Println("LiveTV starting...")
-	go service.LoadChannelCache()
-	c := cron.New()
-	//_, err = c.AddFunc("0 */3 * * *", service.UpdateURLCache)
-	_, err = c.AddFunc("@every 3h", service.UpdateURLCache)
-	if err != nil {
-		log.Panicf("preloadCron: %s\n", err)
+	service.LoadScripts()
+	service.LoadExecParsers()
+	if *configFile != "" {
+		if _, err := service.ApplyConfigFile(*configFile, false); err != nil {
+			log.Println("config file:", err)
+		}
 	}
-	c.Start()
+	service.RestoreURLCache()
+	go service.LoadChannelCache()
+	service.StartScheduler()
 	sessionSecert, err := global.GetConfig("password")
 	if err != nil {
 		sessionSecert = "sessionSecert"
@@ -116,10 +146,25 @@ func main() {
 			log.Panicf("listen: %s\n", err)
 		}
 	}()
+	if *configFile != "" {
+		// SIGHUP reloads the config file
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				log.Println("reloading", *configFile)
+				if _, err := service.ApplyConfigFile(*configFile, false); err != nil {
+					log.Println("config file:", err)
+				}
+			}
+		}()
+	}
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shuting down server...")
+	service.Shutdown() // cancel running parses
+	global.FlushCookieJars()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
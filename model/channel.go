@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Channel struct {
 	ID            int    `gorm:"primary_key"`
 	ChannelID     string `gorm:"-:all"`
@@ -9,15 +11,44 @@ type Channel struct {
 	Parser        string
 	Proxy         bool
 	TsProxy       string     // new field for customized live.ts server
+	Decrypt       bool       // decrypt AES-128 segments on the proxy, for clients that can't fetch keys
 	ProxyUrl      string     // proxy for server connection
 	Token         string     `gorm:"-:all"`
 	Category      string     `gorm:"index"`
 	HasSubChannel bool       `gorm:"hassubchn"`
-	Children      []*Channel `gorm: "-:all` // sub channel list
+	Schedule      string     // refresh interval or cron expression, empty for the parser default
+	Timeout       int        // parse timeout in seconds, 0 for the parser default
+	ParserConfig  string     // parser specific settings, usually json
+	UserAgent     string     // request headers sent upstream, empty for the default
+	Referer       string     // request referer
+	Origin        string     // request origin
+	Cookie        string     // request cookies, like "a=1; b=2"
+	Headers       string     // extra request headers, one "Key: Value" per line
+	CookieJar     string     // channels with the same jar name share cookies, empty for a jar of its own
+	Quality       string     // preferred quality: best, worst or a maximum height like 720p, empty for best
+	AudioLang     string     // preferred audio languages of master playlists, like "ja,en"
+	SubsLang      string     // preferred subtitle languages of master playlists
+	Slate         string     // clip shown while the channel is offline: empty for the global one, none, builtin or an uploaded slate
+	AdFilter      string     // rules that drop ad segments from proxied playlists, json
+	Managed       bool       // declared in the config file, read only in the web ui
+	Children      []*Channel `gorm:"-:all"` // sub channel list
 }
 
 type LiveInfo struct {
 	LiveUrl   string
 	Logo      string
 	ExtraInfo string
+	Title     string    // title of the current program if the parser knows it
+	ExpiresAt time.Time // when LiveUrl stops working, zero if unknown
+}
+
+// LiveCache is a resolved LiveInfo kept in the database, so that channels play right after a restart
+type LiveCache struct {
+	URL        string `gorm:"primary_key"` // the channel url
+	LiveUrl    string
+	Logo       string
+	ExtraInfo  string
+	Title      string
+	ExpiresAt  time.Time
+	ResolvedAt time.Time
 }
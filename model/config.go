@@ -1,6 +1,15 @@
 package model
 
+import "time"
+
 type Config struct {
 	Name string `gorm:"primary_key"`
 	Data string
 }
+
+// SchemaVersion records a database migration that has been applied
+type SchemaVersion struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
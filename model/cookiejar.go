@@ -0,0 +1,7 @@
+package model
+
+type CookieJar struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"`
+	Cookies string // netscape cookies.txt
+}
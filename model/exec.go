@@ -0,0 +1,10 @@
+package model
+
+type ExecParser struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"` // also the parser name used by channels
+	Command string
+	Args    string // {url} is replaced by the channel url
+	Timeout int    // seconds
+	Enabled bool
+}
@@ -0,0 +1,8 @@
+package model
+
+type Script struct {
+	ID      int    `gorm:"primary_key"`
+	Name    string `gorm:"unique_index"` // also the parser name used by channels
+	Code    string
+	Enabled bool
+}
@@ -0,0 +1,11 @@
+package model
+
+type Webhook struct {
+	ID       int `gorm:"primary_key"`
+	Name     string
+	URL      string
+	Headers  string // extra request headers, one "Key: Value" per line
+	Template string // text/template for the request body, the event is sent as json if empty
+	Events   string // comma separated list of events to subscribe, empty for all
+	Enabled  bool
+}
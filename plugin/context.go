@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+type channelKey struct{}
+
+// ConfigValidator checks the per-channel parser config before a channel is saved
+type ConfigValidator interface {
+	ValidateConfig(config string) error
+}
+
+// WithChannel attaches the channel being parsed to ctx, so that context aware parsers can read its settings
+func WithChannel(ctx context.Context, ch *model.Channel) context.Context {
+	return context.WithValue(ctx, channelKey{}, ch)
+}
+
+// ChannelFromContext returns the channel being parsed, nil if unknown
+func ChannelFromContext(ctx context.Context) *model.Channel {
+	ch, _ := ctx.Value(channelKey{}).(*model.Channel)
+	return ch
+}
+
+// ChannelHeaders returns the request headers configured on a channel
+func ChannelHeaders(ch *model.Channel) http.Header {
+	if ch == nil {
+		return http.Header{}
+	}
+	header := global.ParseHeaderLines(ch.Headers)
+	for k, v := range map[string]string{
+		"User-Agent": ch.UserAgent,
+		"Referer":    ch.Referer,
+		"Origin":     ch.Origin,
+		"Cookie":     ch.Cookie,
+	} {
+		if v = strings.TrimSpace(v); v != "" {
+			header.Set(k, v)
+		}
+	}
+	return header
+}
+
+// ApplyChannelHeaders sets the headers configured on ch to req, replacing the existing ones
+func ApplyChannelHeaders(req *http.Request, ch *model.Channel) {
+	for k, v := range ChannelHeaders(ch) {
+		req.Header[k] = v
+	}
+}
+
+// set the headers of the channel being parsed, and the default user agent if none was given
+func setRequestHeaders(req *http.Request) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", global.DefaultUserAgent)
+	}
+	ApplyChannelHeaders(req, ChannelFromContext(req.Context()))
+}
+
+// pass headers a parser found to the ts proxy as header* query parameters
+func tsLinkWithHeaders(tsLink string, headers map[string]string) string {
+	if len(headers) == 0 {
+		return tsLink
+	}
+	u, err := url.Parse(tsLink)
+	if err != nil {
+		return tsLink
+	}
+	q := u.Query()
+	for k, v := range headers {
+		q.Add("header"+k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// sub channels are requested the same way as their parent, share its cookies and prefer the same quality, languages, decryption, slate and ad filter
+func inheritChannelSettings(child *model.Channel, parent *model.Channel) {
+	child.UserAgent = parent.UserAgent
+	child.Referer = parent.Referer
+	child.Origin = parent.Origin
+	child.Cookie = parent.Cookie
+	child.Headers = parent.Headers
+	child.CookieJar = CookieJarName(parent)
+	child.Quality = parent.Quality
+	child.AudioLang = parent.AudioLang
+	child.SubsLang = parent.SubsLang
+	child.Decrypt = parent.Decrypt
+	child.Slate = parent.Slate
+	child.AdFilter = parent.AdFilter
+}
+
+// CookieJarName returns the name of the jar a channel keeps its cookies in.
+// It's empty for a channel that isn't saved yet, or a sub channel of one, unless a jar is named: they have no jar of their own.
+func CookieJarName(ch *model.Channel) string {
+	if ch.CookieJar != "" {
+		return ch.CookieJar
+	}
+	if ch.ID == 0 || strings.HasPrefix(ch.ChannelID, "0-") {
+		return ""
+	}
+	return fmt.Sprintf("channel-%d", ch.ID)
+}
+
+// ChannelCookieJar returns the cookie jar of a channel, the shared in-memory jar if the channel is unknown or has no jar
+func ChannelCookieJar(ch *model.Channel) http.CookieJar {
+	if ch == nil {
+		return global.CookieJar
+	}
+	name := CookieJarName(ch)
+	if name == "" {
+		return global.CookieJar
+	}
+	return global.GetCookieJar(name)
+}
+
+// the cookie jar of the channel being parsed
+func cookieJar(ctx context.Context) http.CookieJar {
+	return ChannelCookieJar(ChannelFromContext(ctx))
+}
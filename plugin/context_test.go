@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+func TestChannelCookieJar(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel *model.Channel
+		jar     string
+	}{
+		{"saved", &model.Channel{ID: 3}, "channel-3"},
+		{"named", &model.Channel{ID: 3, CookieJar: "shared"}, "shared"},
+		{"unsaved", &model.Channel{}, ""},
+		{"unsaved with a named jar", &model.Channel{CookieJar: "shared"}, "shared"},
+		{"sub channel", &model.Channel{ID: 42, ChannelID: "3-42"}, "channel-42"},
+		{"sub channel of an unsaved channel", &model.Channel{ID: 42, ChannelID: "0-42"}, ""},
+	}
+	for _, tt := range tests {
+		if got := CookieJarName(tt.channel); got != tt.jar {
+			t.Errorf("%s: jar %q, want %q", tt.name, got, tt.jar)
+		}
+		jar := ChannelCookieJar(tt.channel)
+		if tt.jar == "" && jar != global.CookieJar {
+			t.Errorf("%s: got a jar of its own, want the shared in-memory jar", tt.name)
+		}
+		if tt.jar != "" && jar != global.GetCookieJar(tt.jar) {
+			t.Errorf("%s: not the jar %q", tt.name, tt.jar)
+		}
+	}
+
+	// sub channels share the jar of their parent, an unsaved parent has none to share
+	child := &model.Channel{ID: 42, ChannelID: "0-42"}
+	inheritChannelSettings(child, &model.Channel{})
+	if got := CookieJarName(child); got != "" {
+		t.Errorf("the sub channel of an unsaved channel has the jar %q", got)
+	}
+}
@@ -2,11 +2,14 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
@@ -38,7 +41,22 @@ func (p *DirectM3U8Parser) TransformTs(rawLink string, tsLink string, info *mode
 	return tsLink
 }
 
-func (p *DirectM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string, content io.Reader) (*model.LiveInfo, error) {
+// besides the common markers, tencent cloud signs its urls with a hex timestamp in txTime
+func (p *DirectM3U8Parser) ExtractExpiry(info *model.LiveInfo) time.Time {
+	if t := URLExpiry(info.LiveUrl); !t.IsZero() {
+		return t
+	}
+	if u, err := url.Parse(info.LiveUrl); err == nil {
+		if txTime := u.Query().Get("txTime"); txTime != "" {
+			if n, err := strconv.ParseInt(txTime, 16, 64); err == nil {
+				return parseExpiryStamp(strconv.FormatInt(n, 10))
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (p *DirectM3U8Parser) Parse(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string, content io.Reader) (*model.LiveInfo, error) {
 	u, err := url.Parse(liveUrl)
 	if err != nil {
 		return nil, err
@@ -51,15 +69,15 @@ func (p *DirectM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraI
 	}
 
 	if content == nil {
-		req, err := http.NewRequest("GET", liveUrl, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set("User-Agent", DefaultUserAgent)
 		// allow adding custom transformations
 		p.Transform(req, &model.LiveInfo{
 			ExtraInfo: previousExtraInfo,
 		})
+		setRequestHeaders(req)
 		resp, err := cloudScraper(req, proxyUrl) // client.Do(req)
 		if err != nil {
 			return nil, err
@@ -69,7 +87,7 @@ func (p *DirectM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraI
 		defer global.CloseBody(resp)
 	}
 
-	bestUrl, err := bestFromMasterPlaylist(liveUrl, proxyUrl, content) // extract the best quality live url from the master playlist
+	bestUrl, err := bestFromMasterPlaylist(ctx, liveUrl, proxyUrl, content) // extract the best quality live url from the master playlist
 	if err == nil {
 		li := &model.LiveInfo{}
 		if !global.IsValidURL(bestUrl) {
@@ -0,0 +1,218 @@
+// exec
+// parsers implemented by external programs, speaking json over stdin/stdout
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+// sent to the program's stdin
+type ExecRequest struct {
+	URL     string            `json:"url"`
+	Proxy   string            `json:"proxy"`
+	Extra   string            `json:"extra"`
+	Headers map[string]string `json:"headers"`
+}
+
+type ExecChannel struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Logo     string `json:"logo"`
+	Category string `json:"category"`
+	Parser   string `json:"parser"`
+}
+
+// read from the program's stdout
+type ExecResponse struct {
+	URL      string            `json:"url"`
+	Logo     string            `json:"logo"`
+	Title    string            `json:"title"`
+	Extra    string            `json:"extra"`
+	Headers  map[string]string `json:"headers"`
+	Expires  int64             `json:"expires"`
+	Channels []ExecChannel     `json:"channels"`
+	Error    string            `json:"error"`
+}
+
+// stored in LiveInfo.ExtraInfo
+type execExtraInfo struct {
+	Extra    string            `json:"extra"`
+	Headers  map[string]string `json:"headers"`
+	Channels []ExecChannel     `json:"channels"`
+}
+
+type ExecParser struct {
+	name    string
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+const maxExecOutputSize = 10 * 1024 * 1024
+
+var errExecOutputTooLarge = fmt.Errorf("output is larger than %d bytes", maxExecOutputSize)
+
+// a buffer that gives up once the program writes more than maxExecOutputSize
+type execOutput struct {
+	buf      bytes.Buffer // not embedded, its ReadFrom would bypass Write
+	exceeded bool
+	kill     context.CancelFunc
+}
+
+func (o *execOutput) Write(b []byte) (int, error) {
+	if o.buf.Len()+len(b) > maxExecOutputSize {
+		if !o.exceeded {
+			o.exceeded = true
+			o.kill() // or it would block on a pipe nobody reads until the timeout
+		}
+		return 0, errExecOutputTooLarge
+	}
+	return o.buf.Write(b)
+}
+
+func NewExecParser(name string, command string, args string, timeout time.Duration) (*ExecParser, error) {
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, err
+	}
+	return &ExecParser{
+		name:    name,
+		command: command,
+		args:    strings.Fields(args),
+		timeout: timeout,
+	}, nil
+}
+
+func (p *ExecParser) dynamic() string { return ExecKind }
+
+func (p *ExecParser) Timeout() time.Duration {
+	return p.timeout
+}
+
+func unpackExecExtra(extraInfo string) execExtraInfo {
+	var ei execExtraInfo
+	json.Unmarshal([]byte(extraInfo), &ei)
+	return ei
+}
+
+func (p *ExecParser) Transform(req *http.Request, info *model.LiveInfo) error {
+	for k, v := range unpackExecExtra(info.ExtraInfo).Headers {
+		req.Header.Set(k, v)
+	}
+	return nil
+}
+
+func (p *ExecParser) TransformTs(rawLink string, tsLink string, info *model.LiveInfo) string {
+	return tsLinkWithHeaders(tsLink, unpackExecExtra(info.ExtraInfo).Headers)
+}
+
+func (p *ExecParser) Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) (channels []*model.Channel) {
+	for i, it := range unpackExecExtra(liveInfo.ExtraInfo).Channels {
+		parser := it.Parser
+		if parser == "" {
+			parser = "http"
+		}
+		channel := &model.Channel{
+			ID:        i,
+			ChannelID: fmt.Sprintf("%d-%d", parentChannel.ID, i),
+			Category:  it.Category,
+			Name:      it.Name,
+			Logo:      it.Logo,
+			Parser:    parser,
+			URL:       it.URL,
+			ProxyUrl:  parentChannel.ProxyUrl,
+			Proxy:     parentChannel.Proxy,
+			TsProxy:   parentChannel.TsProxy,
+		}
+		inheritChannelSettings(channel, parentChannel)
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (p *ExecParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return p.ParseContext(ctx, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *ExecParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	prev := unpackExecExtra(previousExtraInfo)
+	// the program makes its own requests, tell it what the channel is configured with
+	for k, v := range ChannelHeaders(ChannelFromContext(ctx)) {
+		if prev.Headers == nil {
+			prev.Headers = make(map[string]string)
+		}
+		prev.Headers[k] = v[0]
+	}
+	input, _ := json.Marshal(&ExecRequest{
+		URL:     liveUrl,
+		Proxy:   proxyUrl,
+		Extra:   prev.Extra,
+		Headers: prev.Headers,
+	})
+	args := make([]string, len(p.args))
+	for i, v := range p.args {
+		args[i] = strings.ReplaceAll(v, "{url}", liveUrl)
+	}
+	ctx, kill := context.WithCancel(ctx)
+	defer kill()
+	cmd := exec.CommandContext(ctx, p.command, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	stdout, stderr := &execOutput{kill: kill}, &execOutput{kill: kill}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second // children of a killed program may hold its stdout open
+	if err := cmd.Run(); err != nil {
+		if stdout.exceeded || stderr.exceeded {
+			return nil, errors.New(p.name + ": " + errExecOutputTooLarge.Error())
+		}
+		if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+			return nil, errors.Join(errors.New(msg), err)
+		}
+		return nil, err
+	}
+	var resp ExecResponse
+	if err := json.NewDecoder(&stdout.buf).Decode(&resp); err != nil {
+		return nil, errors.Join(errors.New(p.name+" returned invalid json"), err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.URL == "" && len(resp.Channels) == 0 {
+		return nil, NoMatchFeed
+	}
+	js, _ := json.Marshal(&execExtraInfo{
+		Extra:    resp.Extra,
+		Headers:  resp.Headers,
+		Channels: resp.Channels,
+	})
+	li := &model.LiveInfo{
+		LiveUrl:   resp.URL,
+		Logo:      resp.Logo,
+		Title:     resp.Title,
+		ExtraInfo: string(js),
+	}
+	if resp.Expires > 0 {
+		li.ExpiresAt = time.Unix(resp.Expires, 0)
+	}
+	return li, nil
+}
+
+// RegisterExec registers an external program as a parser
+func RegisterExec(name string, command string, args string, timeout time.Duration, priority int) error {
+	p, err := NewExecParser(name, command, args, timeout)
+	if err != nil {
+		return err
+	}
+	return registerDynamic(name, p, priority)
+}
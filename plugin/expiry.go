@@ -0,0 +1,73 @@
+// expiry
+package plugin
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+// extract the time when the resolved url stops working, zero time if unknown
+type ExpiryExtractor interface {
+	ExtractExpiry(info *model.LiveInfo) time.Time
+}
+
+// stamps closer than this are stale values or clock skew, a url expiring so soon would be reparsed over and over
+const minExpiryLifetime = 30 * time.Second
+
+var (
+	pathExpiryRegex = regexp.MustCompile(`/expires?/(\d{10,13})(?:/|$)`)
+	expiryQueryKeys = []string{"expire", "expires", "e"}
+)
+
+// convert a unix timestamp (in seconds or milliseconds) into time, reject values that can't be an expiry
+func parseExpiryStamp(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	if n > 1e12 {
+		n /= 1000 // milliseconds
+	}
+	t := time.Unix(n, 0)
+	now := time.Now()
+	if t.Before(now.Add(minExpiryLifetime)) || t.After(now.Add(30*24*time.Hour)) {
+		return time.Time{}
+	}
+	return t
+}
+
+// URLExpiry finds common expiry markers in signed urls, like googlevideo's /expire/<unix>/ or expires=<unix>
+func URLExpiry(liveUrl string) time.Time {
+	u, err := url.Parse(liveUrl)
+	if err != nil {
+		return time.Time{}
+	}
+	if m := pathExpiryRegex.FindStringSubmatch(u.Path); m != nil {
+		if t := parseExpiryStamp(m[1]); !t.IsZero() {
+			return t
+		}
+	}
+	q := u.Query()
+	for _, key := range expiryQueryKeys {
+		if v := q.Get(key); v != "" {
+			if t := parseExpiryStamp(v); !t.IsZero() {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// GetExpiry asks the parser for the expiry of info, falls back to URLExpiry
+func GetExpiry(parser string, info *model.LiveInfo) time.Time {
+	if p, err := GetPlugin(parser); err == nil {
+		if extractor, ok := p.(ExpiryExtractor); ok {
+			return extractor.ExtractExpiry(info)
+		}
+	}
+	return URLExpiry(info.LiveUrl)
+}
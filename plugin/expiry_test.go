@@ -0,0 +1,31 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestURLExpiry(t *testing.T) {
+	now := time.Now()
+	future := now.Add(6 * time.Hour).Unix()
+	tests := []struct {
+		url  string
+		want int64 // unix seconds, 0 for unknown
+	}{
+		{fmt.Sprintf("https://r1.googlevideo.com/videoplayback/expire/%d/ei/abc/index.m3u8", future), future},
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?token=x&expires=%d", future), future},
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?e=%d", future*1000), future}, // milliseconds
+		{"https://cdn.example.com/live.m3u8", 0},
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?expires=%d", now.Add(-time.Hour).Unix()), 0},      // already expired
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?expires=%d", now.Add(10*time.Second).Unix()), 0},  // about to expire
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?expires=%d", now.Add(60*24*time.Hour).Unix()), 0}, // too far ahead
+		{fmt.Sprintf("https://cdn.example.com/live.m3u8?expire=soon&expires=%d", future), future},
+	}
+	for _, tt := range tests {
+		got := URLExpiry(tt.url)
+		if tt.want == 0 && !got.IsZero() || tt.want != 0 && got.Unix() != tt.want {
+			t.Errorf("URLExpiry(%s) = %v, want %d", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// flvts
+// remuxes the H.264/AAC packets of flv streams and mp4 clips into MPEG-TS
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+)
+
+const (
+	tsPMTPid   = 0x1000
+	tsVideoPid = 0x100
+	tsAudioPid = 0x101
+	// timestamps are shifted by a second so that the pcr can run ahead of them
+	tsTimeOffset = 90000
+)
+
+var annexbStartCode = []byte{0, 0, 0, 1}
+
+// TSMuxer writes joy5 packets as MPEG-TS, tables are repeated at every keyframe
+type TSMuxer struct {
+	w          io.Writer
+	hasVideo   bool
+	hasAudio   bool
+	counters   map[uint16]byte
+	sps        [][]byte
+	pps        [][]byte
+	aacConfig  *aac.MPEG4AudioConfig
+	audioCount int
+	buf        [188]byte
+}
+
+func NewTSMuxer(w io.Writer, hasVideo bool, hasAudio bool) *TSMuxer {
+	if !hasVideo && !hasAudio {
+		hasVideo, hasAudio = true, true
+	}
+	return &TSMuxer{w: w, hasVideo: hasVideo, hasAudio: hasAudio, counters: make(map[uint16]byte)}
+}
+
+// crc32 as used by MPEG-2 sections, msb first without final xor
+func crc32MPEG2(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (m *TSMuxer) nextCounter(pid uint16) byte {
+	cc := m.counters[pid]
+	m.counters[pid] = (cc + 1) & 0xf
+	return cc
+}
+
+func (m *TSMuxer) writeSection(pid uint16, section []byte) error {
+	crc := crc32MPEG2(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+	pkt := m.buf[:]
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid>>8)&0x1f
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | m.nextCounter(pid)
+	pkt[4] = 0 // pointer field
+	copy(pkt[5:], section)
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+func (m *TSMuxer) pcrPid() uint16 {
+	if m.hasVideo {
+		return tsVideoPid
+	}
+	return tsAudioPid
+}
+
+func (m *TSMuxer) writeTables() error {
+	pat := []byte{0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xe0 | tsPMTPid>>8, tsPMTPid & 0xff}
+	if err := m.writeSection(0, pat); err != nil {
+		return err
+	}
+	var streams []byte
+	if m.hasVideo {
+		streams = append(streams, 0x1b, 0xe0|tsVideoPid>>8, tsVideoPid&0xff, 0xf0, 0x00)
+	}
+	if m.hasAudio {
+		streams = append(streams, 0x0f, 0xe0|tsAudioPid>>8, tsAudioPid&0xff, 0xf0, 0x00)
+	}
+	pcr := m.pcrPid()
+	pmt := []byte{0x02, 0xb0, byte(9 + len(streams) + 4), 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe0 | byte(pcr>>8), byte(pcr), 0xf0, 0x00}
+	return m.writeSection(tsPMTPid, append(pmt, streams...))
+}
+
+// 33 bit pts/dts with the 4 bit prefix and marker bits
+func appendTimestamp(b []byte, prefix byte, ts int64) []byte {
+	return append(b,
+		prefix<<4|byte(ts>>29)&0x0e|1,
+		byte(ts>>22),
+		byte(ts>>14)|1,
+		byte(ts>>7),
+		byte(ts<<1)|1)
+}
+
+func pesHeader(streamId byte, payloadLen int, pts int64, dts int64) []byte {
+	header := []byte{0, 0, 1, streamId, 0, 0, 0x80, 0x80, 5}
+	if dts != pts {
+		header[7], header[8] = 0xc0, 10
+		header = appendTimestamp(header, 0x3, pts)
+		header = appendTimestamp(header, 0x1, dts)
+	} else {
+		header = appendTimestamp(header, 0x2, pts)
+	}
+	// video pes may be unbounded
+	if length := len(header) - 6 + payloadLen; length <= 0xffff && streamId != 0xe0 {
+		header[4], header[5] = byte(length>>8), byte(length)
+	}
+	return header
+}
+
+// split a pes into ts packets, the first one carries the pcr and the random access flag
+func (m *TSMuxer) writePES(pid uint16, pes []byte, pcr int64, keyframe bool) error {
+	for first := true; first || len(pes) > 0; first = false {
+		pkt := m.buf[:]
+		pkt[0] = 0x47
+		pkt[1] = byte(pid>>8) & 0x1f
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		var af []byte // adaptation field without its length byte
+		hasAF := false
+		if first && (pcr >= 0 || keyframe) {
+			hasAF = true
+			af = []byte{0}
+			if keyframe {
+				af[0] |= 0x40
+			}
+			if pcr >= 0 {
+				af[0] |= 0x10
+				af = append(af, byte(pcr>>25), byte(pcr>>17), byte(pcr>>9), byte(pcr>>1), byte(pcr<<7)|0x7e, 0)
+			}
+		}
+		space := 184
+		if hasAF {
+			space -= 1 + len(af)
+		}
+		if len(pes) < space {
+			// stuff the adaptation field so that the payload ends with the packet
+			pad := space - len(pes)
+			if !hasAF {
+				hasAF = true
+				pad--
+				if pad > 0 {
+					af = []byte{0}
+					pad--
+				}
+			}
+			af = append(af, bytes.Repeat([]byte{0xff}, pad)...)
+			space = len(pes)
+		}
+		pos := 4
+		if hasAF {
+			pkt[3] = 0x30 | m.nextCounter(pid)
+			pkt[4] = byte(len(af))
+			copy(pkt[5:], af)
+			pos = 5 + len(af)
+		} else {
+			pkt[3] = 0x10 | m.nextCounter(pid)
+		}
+		copy(pkt[pos:], pes[:space])
+		pes = pes[space:]
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func to90k(d time.Duration) int64 {
+	return int64(d/time.Millisecond) * 90
+}
+
+func (m *TSMuxer) WritePacket(pkt av.Packet) error {
+	switch pkt.Type {
+	case av.H264DecoderConfig:
+		codec, err := h264.FromDecoderConfig(pkt.Data)
+		if err != nil {
+			return err
+		}
+		m.sps, m.pps = h264.Map2arr(codec.SPS), h264.Map2arr(codec.PPS)
+	case av.AACDecoderConfig:
+		codec, err := aac.FromMPEG4AudioConfigBytes(pkt.Data)
+		if err != nil {
+			return err
+		}
+		m.aacConfig = &codec.Config
+	case av.H264:
+		if !m.hasVideo {
+			return nil
+		}
+		nalus, _ := h264.SplitNALUs(pkt.Data)
+		// access unit delimiter, then the parameter sets for decoders joining at this keyframe
+		payload := []byte{0, 0, 0, 1, 0x09, 0xf0}
+		if pkt.IsKeyFrame {
+			if err := m.writeTables(); err != nil {
+				return err
+			}
+			for _, ps := range append(m.sps, m.pps...) {
+				payload = append(append(payload, annexbStartCode...), ps...)
+			}
+		}
+		for _, nalu := range nalus {
+			if h264.NALUType(nalu) == h264.NALU_AUD {
+				continue
+			}
+			payload = append(append(payload, annexbStartCode...), nalu...)
+		}
+		dts := to90k(pkt.Time)
+		pts := dts + to90k(pkt.CTime)
+		pes := append(pesHeader(0xe0, len(payload), (pts+tsTimeOffset)&0x1ffffffff, (dts+tsTimeOffset)&0x1ffffffff), payload...)
+		return m.writePES(tsVideoPid, pes, dts&0x1ffffffff, pkt.IsKeyFrame)
+	case av.AAC:
+		if !m.hasAudio || m.aacConfig == nil {
+			return nil
+		}
+		pcr := int64(-1)
+		if !m.hasVideo {
+			// audio only streams carry the tables and the clock themselves
+			if m.audioCount%50 == 0 {
+				if err := m.writeTables(); err != nil {
+					return err
+				}
+				pcr = to90k(pkt.Time) & 0x1ffffffff
+			}
+			m.audioCount++
+		}
+		payload := make([]byte, aac.ADTSHeaderLength+len(pkt.Data))
+		aac.FillADTSHeader(payload, *m.aacConfig, 1024, len(pkt.Data))
+		copy(payload[aac.ADTSHeaderLength:], pkt.Data)
+		pts := (to90k(pkt.Time) + tsTimeOffset) & 0x1ffffffff
+		pes := append(pesHeader(0xc0, len(payload), pts, pts), payload...)
+		return m.writePES(tsAudioPid, pes, pcr, false)
+	}
+	return nil
+}
@@ -3,13 +3,13 @@ package plugin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
@@ -20,22 +20,25 @@ type URLM3U8Parser struct {
 }
 
 func (p *URLM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *URLM3U8Parser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
 	client := http.Client{
-		Timeout:   time.Second * 10,
 		Transport: global.TransportWithProxy(proxyUrl),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
-		Jar: global.CookieJar,
+		Jar: cookieJar(ctx),
 	}
-	req, err := http.NewRequest("GET", liveUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
 	p.Transform(req, &model.LiveInfo{
 		ExtraInfo: previousExtraInfo,
 	})
+	setRequestHeaders(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -63,8 +66,8 @@ func (p *URLM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraInfo
 
 		ui.RedirectCounter = pei.RedirectCounter + 1
 		js, _ := json.Marshal(ui)
-		previousExtraInfo = string(js)                           // write headers info to extraInfo
-		info, err := p.Parse(redir, proxyUrl, previousExtraInfo) // recursive call the parser to follow redirections
+		previousExtraInfo = string(js)                                       // write headers info to extraInfo
+		info, err := p.ParseContext(ctx, redir, proxyUrl, previousExtraInfo) // recursive call the parser to follow redirections
 		if err == nil && info != nil {
 			info.Logo = ui.Logo
 		}
@@ -77,12 +80,12 @@ func (p *URLM3U8Parser) Parse(liveUrl string, proxyUrl string, previousExtraInfo
 	if strings.Contains(contentType, "mpegurl") {
 		js, _ := json.Marshal(pei)
 		previousExtraInfo = string(js)
-		return p.DirectM3U8Parser.Parse(liveUrl, proxyUrl, previousExtraInfo, resp.Body)
+		return p.DirectM3U8Parser.Parse(ctx, liveUrl, proxyUrl, previousExtraInfo, resp.Body)
 	} else {
 		if strings.Contains(contentType, "text") {
 			content := &bytes.Buffer{}
 			io.Copy(content, resp.Body)
-			if li, err := p.DirectM3U8Parser.Parse(liveUrl, proxyUrl, previousExtraInfo, content); err == nil {
+			if li, err := p.DirectM3U8Parser.Parse(ctx, liveUrl, proxyUrl, previousExtraInfo, content); err == nil {
 				return li, err
 			} else {
 				log.Println("Server error response:", content.String())
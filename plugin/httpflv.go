@@ -0,0 +1,171 @@
+// http-flv
+// serves http-flv sources as they are, or remuxed to MPEG-TS for players that can't play flv
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nareix/joy5/format/flv"
+	"github.com/nareix/joy5/format/flv/flvio"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+type HTTPFLVParser struct{}
+
+// the output format of a request, ?format= of the client overrides the channel config
+func flvOutputFormat(c *gin.Context) string {
+	format := c.Query("format")
+	if format == "" {
+		if ch := ChannelFromContext(c.Request.Context()); ch != nil {
+			format = ch.ParserConfig
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(format), "ts") {
+		return "ts"
+	}
+	return "flv"
+}
+
+func (p *HTTPFLVParser) ValidateConfig(config string) error {
+	switch strings.ToLower(strings.TrimSpace(config)) {
+	case "", "flv", "ts":
+		return nil
+	}
+	return errors.New("http-flv config must be flv or ts")
+}
+
+// request the flv stream with the channel's headers, proxy and cookies
+func openFLV(ctx context.Context, liveUrl string) (*http.Response, *bufio.Reader, error) {
+	proxyUrl := ""
+	if ch := ChannelFromContext(ctx); ch != nil {
+		proxyUrl = ch.ProxyUrl
+	}
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	setRequestHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("flv server response: HTTP %d", resp.StatusCode)
+	}
+	// the content type of flv streams is often wrong, trust the signature instead
+	r := bufio.NewReader(resp.Body)
+	signature, err := r.Peek(3)
+	if err != nil || string(signature) != "FLV" {
+		resp.Body.Close()
+		return nil, nil, errors.New("Invalid feed: " + resp.Header.Get("Content-Type"))
+	}
+	return resp, r, nil
+}
+
+func (p *HTTPFLVParser) Host(c *gin.Context, info *model.LiveInfo) error {
+	resp, r, err := openFLV(c.Request.Context(), info.LiveUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	format := flvOutputFormat(c)
+	log.Println("Start serving", info.LiveUrl, "as", format)
+	defer log.Println("Serving finished", info.LiveUrl)
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+	if format == "flv" {
+		c.Writer.Header().Set("Content-Type", "video/x-flv")
+		c.Writer.WriteHeader(200)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+					break
+				}
+				c.Writer.Flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+		return nil
+	}
+
+	var flags uint8
+	demuxer := flv.NewDemuxer(r)
+	demuxer.LogHeaderEvent = func(f uint8) {
+		flags = f
+	}
+	if err = demuxer.ReadFileHeader(); err != nil {
+		return err
+	}
+	c.Writer.Header().Set("Content-Type", "video/mp2t")
+	c.Writer.WriteHeader(200)
+	muxer := NewTSMuxer(c.Writer, flags&flvio.FILE_HAS_VIDEO != 0, flags&flvio.FILE_HAS_AUDIO != 0)
+	for {
+		packet, err := demuxer.ReadPacket()
+		if err != nil {
+			log.Println("stream ended with error", err)
+			break
+		}
+		if err = muxer.WritePacket(packet); err != nil {
+			break
+		}
+		c.Writer.Flush()
+	}
+	return nil
+}
+
+func (p *HTTPFLVParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+// make sure the url serves flv, redirections are followed to the final stream url
+func (p *HTTPFLVParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// a live stream never ends, don't drain it
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, NoMatchFeed
+	}
+	signature := make([]byte, 3)
+	if _, err := io.ReadFull(resp.Body, signature); err != nil || string(signature) != "FLV" {
+		return nil, errors.New("Invalid feed: " + resp.Header.Get("Content-Type"))
+	}
+	li := &model.LiveInfo{}
+	li.LiveUrl = resp.Request.URL.String()
+	li.ExtraInfo = previousExtraInfo
+	return li, nil
+}
+
+func init() {
+	registerPlugin("http-flv", &HTTPFLVParser{}, 12)
+}
@@ -2,13 +2,13 @@ package plugin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
@@ -34,24 +34,27 @@ type M3UPlayList struct {
 }
 
 func (p *M3UParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *M3UParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
 	_, err := url.Parse(liveUrl)
 	if err != nil {
 		return nil, err
 	}
 
 	client := http.Client{
-		Timeout:   time.Second * 10,
 		Transport: global.TransportWithProxy(proxyUrl),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
-		Jar: global.CookieJar,
+		Jar: cookieJar(ctx),
 	}
-	req, err := http.NewRequest("GET", liveUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	setRequestHeaders(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -132,6 +135,11 @@ func (p *M3UParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo str
 	return nil, errors.New("Unsupported playlist format")
 }
 
+// playlists rarely change
+func (p *M3UParser) Schedule() string {
+	return "@every 12h"
+}
+
 // channel provider
 func (p *M3UParser) Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) (channels []*model.Channel) {
 	var parsedList []ParsedChannel
@@ -149,6 +157,7 @@ func (p *M3UParser) Channels(parentChannel *model.Channel, liveInfo *model.LiveI
 			Proxy:     parentChannel.Proxy,
 			TsProxy:   parentChannel.TsProxy,
 		}
+		inheritChannelSettings(channel, parentChannel)
 		channels = append(channels, channel)
 	}
 	return channels
@@ -4,12 +4,16 @@ package plugin
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -28,6 +32,16 @@ type Plugin interface {
 	Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (info *model.LiveInfo, error error)
 }
 
+// context aware parser, used instead of Parse when implemented so that the parse can be cancelled
+type ContextParser interface {
+	ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (info *model.LiveInfo, error error)
+}
+
+// parsers that need a different timeout than global.HttpClientTimeout
+type TimeoutProvider interface {
+	Timeout() time.Duration
+}
+
 type ChannalProvider interface {
 	Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) []*model.Channel
 }
@@ -42,6 +56,11 @@ type HealthCheck interface {
 	Check(content string, info *model.LiveInfo) error
 }
 
+// a HealthCheck result that isn't worth a reparse: the playlist is served and the message shows as a warning in the channel status
+type HealthWarning string
+
+func (w HealthWarning) Error() string { return string(w) }
+
 // host a live feed directly instead of generating a m3u8 playlist
 type FeedHost interface {
 	Host(c *gin.Context, info *model.LiveInfo) error
@@ -49,7 +68,12 @@ type FeedHost interface {
 
 // Allow a plugin to generate a custom M3U8 playlist instead of requesting from the internet
 type Forger interface {
-	ForgeM3U8(info *model.LiveInfo) (baseUrl string, body string, err error)
+	ForgeM3U8(ctx context.Context, info *model.LiveInfo) (baseUrl string, body string, err error)
+}
+
+// provide a default refresh schedule (interval or cron expression) for channels using this parser
+type Scheduler interface {
+	Schedule() string
 }
 
 // transform the tsproxy link
@@ -63,27 +87,119 @@ type UrlInfo struct {
 	RedirectCounter int32             `json:"redir"`
 }
 
+// parsers defined by admins at runtime, like scripts and external programs
+type dynamicPlugin interface {
+	Plugin
+	dynamic() string // the kind of parser
+}
+
+// the kinds of dynamic parsers, scripts and external programs share one namespace
+const (
+	ScriptKind = "script"
+	ExecKind   = "exec"
+)
+
 type pluginInfo struct {
 	instance Plugin
 	priority int
 }
 
 var (
+	pluginLock    sync.RWMutex          // scripts can be registered at runtime
 	pluginCenter  map[string]pluginInfo = make(map[string]pluginInfo)
 	NoMatchPlugin error                 = errors.New("No matching plugin found")
 	NoMatchFeed   error                 = errors.New("This channel is not currently live")
 )
 
-const (
-	DefaultUserAgent string = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
-)
-
 func registerPlugin(name string, parser Plugin, priority int) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	pluginCenter[name] = pluginInfo{parser, priority}
+}
+
+// register a runtime defined parser, built-in parsers and dynamic ones of another kind can't be replaced
+func registerDynamic(name string, parser dynamicPlugin, priority int) error {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	if err := checkDynamicName(name, parser.dynamic()); err != nil {
+		return err
+	}
 	pluginCenter[name] = pluginInfo{parser, priority}
+	return nil
+}
+
+func checkDynamicName(name string, kind string) error {
+	if existing, ok := pluginCenter[name]; ok {
+		dp, ok := existing.instance.(dynamicPlugin)
+		if !ok {
+			return errors.New(name + " is a built-in parser")
+		}
+		if dp.dynamic() != kind {
+			return fmt.Errorf("%s is already used by a parser of type %s", name, dp.dynamic())
+		}
+	}
+	return nil
+}
+
+// CheckDynamicName tells whether a parser of kind can be registered as name
+func CheckDynamicName(name string, kind string) error {
+	pluginLock.RLock()
+	defer pluginLock.RUnlock()
+	return checkDynamicName(name, kind)
+}
+
+// UnregisterDynamic removes a parser of kind registered by RegisterScript or RegisterExec
+func UnregisterDynamic(name string, kind string) {
+	pluginLock.Lock()
+	defer pluginLock.Unlock()
+	if p, ok := pluginCenter[name]; ok {
+		if dp, ok := p.instance.(dynamicPlugin); ok && dp.dynamic() == kind {
+			delete(pluginCenter, name)
+		}
+	}
+}
+
+// ParseContext runs a parser under ctx.
+// Legacy parsers without ParseContext keep running in the background, but the result is abandoned once ctx is done.
+func ParseContext(ctx context.Context, p Plugin, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	if cp, ok := p.(ContextParser); ok {
+		return cp.ParseContext(ctx, liveUrl, proxyUrl, previousExtraInfo)
+	}
+	type result struct {
+		info *model.LiveInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := p.Parse(liveUrl, proxyUrl, previousExtraInfo)
+		done <- result{info, err}
+	}()
+	select {
+	case r := <-done:
+		return r.info, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// the timeout of a parser, global.HttpClientTimeout if the parser has no preference
+func ParseTimeout(p Plugin) time.Duration {
+	if tp, ok := p.(TimeoutProvider); ok && tp.Timeout() > 0 {
+		return tp.Timeout()
+	}
+	return global.HttpClientTimeout
+}
+
+// implements the legacy Parse of context aware parsers
+func parseWithTimeout(p ContextParser, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), global.HttpClientTimeout)
+	defer cancel()
+	return p.ParseContext(ctx, liveUrl, proxyUrl, previousExtraInfo)
 }
 
 func cloudScraper(req *http.Request, proxyUrl string) (*freq.Response, error) {
 	client := freq.C().ImpersonateFirefox() //.SetCommonContentType("application/x-www-form-urlencoded; charset=UTF-8").SetCommonHeader("accept", "*/*")
+	client.SetCookieJar(cookieJar(req.Context()))
 	if proxyUrl != "" {
 		client.SetDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
 			return global.TransportWithProxy(proxyUrl).Dial(network, addr)
@@ -96,9 +212,9 @@ func cloudScraper(req *http.Request, proxyUrl string) (*freq.Response, error) {
 	}
 	switch req.Method {
 	case http.MethodGet:
-		return client.R().Get(req.URL.String())
+		return client.R().SetContext(req.Context()).Get(req.URL.String())
 	case http.MethodPost:
-		return client.R().SetBody(req.Body).Post(req.URL.String())
+		return client.R().SetContext(req.Context()).SetBody(req.Body).Post(req.URL.String())
 	default:
 		return nil, errors.New("Method not allowed")
 	}
@@ -126,15 +242,16 @@ func cloudScraper(req *http.Request, proxyUrl string) (*freq.Response, error) {
 	// return client.Do(req)
 }
 
-func bestFromMasterPlaylist(masterUrl string, proxyUrl string, content ...io.Reader) (string, error) {
+func bestFromMasterPlaylist(ctx context.Context, masterUrl string, proxyUrl string, content ...io.Reader) (string, error) {
 	var playlist io.Reader
 	if len(content) > 0 {
 		playlist = content[0]
 	} else {
-		req, err := http.NewRequest("GET", masterUrl, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", masterUrl, nil)
 		if err != nil {
 			return "", err
 		}
+		ApplyChannelHeaders(req, ChannelFromContext(ctx))
 		resp, err := cloudScraper(req, proxyUrl)
 		if err != nil {
 			return "", err
@@ -165,17 +282,20 @@ func bestFromMasterPlaylist(masterUrl string, proxyUrl string, content ...io.Rea
 	case m3u8.MASTER:
 		{
 			masterpl := p.(*m3u8.MasterPlaylist)
-			selectedUrl := ""
-			selectedBw := uint32(0)
 			for _, v := range masterpl.Variants {
-				if v.Bandwidth >= selectedBw {
-					selectedUrl = v.URI
-					selectedBw = v.Bandwidth
-				}
 				if v.Audio != "" {
-					return masterUrl, nil // a master playlist mixed with audio and video, we have to preserve the master playlist
+					// separate audio renditions need the master playlist, the quality and languages are chosen when it's served
+					return masterUrl, nil
 				}
 			}
+			variants := masterpl.Variants
+			selected := channelQuality(ctx).Pick(len(variants),
+				func(i int) int { return ResolutionHeight(variants[i].Resolution) },
+				func(i int) float64 { return float64(variants[i].Bandwidth) })
+			if selected < 0 {
+				return "", errors.New("Empty master playlist")
+			}
+			selectedUrl := variants[selected].URI
 			if !global.IsValidURL(selectedUrl) {
 				selectedUrl = global.MergeUrl(global.GetBaseURL(masterUrl), selectedUrl)
 			}
@@ -185,6 +305,15 @@ func bestFromMasterPlaylist(masterUrl string, proxyUrl string, content ...io.Rea
 	return "", errors.New("Unknown type of playlist")
 }
 
+// ResolutionHeight returns the height of a "1280x720" resolution, 0 if unknown
+func ResolutionHeight(resolution string) int {
+	if _, h, ok := strings.Cut(resolution, "x"); ok {
+		height, _ := strconv.Atoi(h)
+		return height
+	}
+	return 0
+}
+
 // regex from https://stackoverflow.com/questions/5830387/how-do-i-find-all-youtube-video-ids-in-a-string-using-a-regex?lq=1
 func getYouTubeVideoID(url string) string {
 	regex := regexp2.MustCompile(`(?:youtu\.be\/|youtube(?:-nocookie)?\.com\S*?[^\w\s-])([\w-]{11})(?=[^\w-]|$)(?![?=&+%\w.-]*(?:['"][^<>]*>|<\/a>))[?=&+%\w.-]*`, 0)
@@ -205,6 +334,8 @@ func getYouTubeChannelID(url string) string {
 }
 
 func GetPlugin(name string) (Plugin, error) {
+	pluginLock.RLock()
+	defer pluginLock.RUnlock()
 	if p, ok := pluginCenter[name]; ok {
 		return p.instance, nil
 	}
@@ -213,6 +344,8 @@ func GetPlugin(name string) (Plugin, error) {
 }
 
 func GetPluginList() []string {
+	pluginLock.RLock()
+	defer pluginLock.RUnlock()
 	list := make([]string, 0)
 	for name, _ := range pluginCenter {
 		list = append(list, name)
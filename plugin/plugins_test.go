@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDynamicNamespace(t *testing.T) {
+	const name = "test-shared-name"
+	if err := RegisterScript(name, "function parse(url) { return null }", 1000); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterDynamic(name, ScriptKind)
+
+	if err := RegisterExec(name, "sh", "-c true", time.Second, 1000); err == nil {
+		t.Fatal("an exec parser replaced the script of the same name")
+	}
+	if err := CheckDynamicName(name, ExecKind); err == nil {
+		t.Error("the name of a script is free for an exec parser")
+	}
+	if err := CheckDynamicName(name, ScriptKind); err != nil {
+		t.Errorf("a script can't be registered again: %v", err)
+	}
+	if err := RegisterScript("http", "function parse(url) { return null }", 1000); err == nil {
+		t.Error("a script replaced a built-in parser")
+	}
+
+	// removing an exec parser of that name leaves the script alone
+	UnregisterDynamic(name, ExecKind)
+	if p, err := GetPlugin(name); err != nil {
+		t.Fatal("the script was unregistered with an exec parser")
+	} else if _, ok := p.(*ScriptParser); !ok {
+		t.Fatalf("%s is a %T", name, p)
+	}
+	UnregisterDynamic(name, ScriptKind)
+	if _, err := GetPlugin(name); err == nil {
+		t.Error("the script is still registered")
+	}
+}
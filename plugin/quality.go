@@ -0,0 +1,62 @@
+// quality
+// the quality a channel prefers when a source offers several
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Quality struct {
+	Worst     bool
+	MaxHeight int // 0 for no limit
+}
+
+// ParseQuality parses "best" (or empty), "worst", or a maximum height like "720p"
+func ParseQuality(s string) (Quality, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "best":
+		return Quality{}, nil
+	case "worst":
+		return Quality{Worst: true}, nil
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(s, "p"))
+	if err != nil || height <= 0 {
+		return Quality{}, errors.New("quality must be best, worst or a height like 720p")
+	}
+	return Quality{MaxHeight: height}, nil
+}
+
+// the quality of the channel being parsed, best if unknown
+func channelQuality(ctx context.Context) Quality {
+	if ch := ChannelFromContext(ctx); ch != nil {
+		q, _ := ParseQuality(ch.Quality)
+		return q
+	}
+	return Quality{}
+}
+
+// Pick picks one of n candidates, an unknown height (0) is taken as fitting any limit.
+// When nothing fits under the height limit, the smallest candidate is used.
+func (q Quality) Pick(n int, height func(i int) int, bandwidth func(i int) float64) int {
+	selected := -1
+	for i := 0; i < n; i++ {
+		if q.MaxHeight > 0 && height(i) > q.MaxHeight {
+			continue
+		}
+		if selected < 0 || (q.Worst && bandwidth(i) <= bandwidth(selected)) || (!q.Worst && bandwidth(i) >= bandwidth(selected)) {
+			selected = i
+		}
+	}
+	if selected < 0 {
+		for i := 0; i < n; i++ {
+			if selected < 0 || height(i) < height(selected) || (height(i) == height(selected) && bandwidth(i) < bandwidth(selected)) {
+				selected = i
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import "testing"
+
+func TestParseQuality(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Quality
+	}{
+		{"", Quality{}},
+		{"best", Quality{}},
+		{" Worst ", Quality{Worst: true}},
+		{"720p", Quality{MaxHeight: 720}},
+		{"1080", Quality{MaxHeight: 1080}},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuality(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseQuality(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"hd", "0p", "-720p"} {
+		if _, err := ParseQuality(in); err == nil {
+			t.Errorf("ParseQuality(%q) should fail", in)
+		}
+	}
+}
+
+func TestQualityPick(t *testing.T) {
+	// height, bandwidth
+	candidates := [][2]int{{720, 3000}, {1080, 6000}, {480, 1500}, {0, 800}, {1080, 5000}}
+	height := func(i int) int { return candidates[i][0] }
+	bandwidth := func(i int) float64 { return float64(candidates[i][1]) }
+	tests := []struct {
+		quality string
+		want    int
+	}{
+		{"best", 1},
+		{"worst", 3},
+		{"720p", 0},
+		{"1080p", 1},
+		{"360p", 3}, // an unknown height fits any limit
+	}
+	for _, tt := range tests {
+		q, _ := ParseQuality(tt.quality)
+		if got := q.Pick(len(candidates), height, bandwidth); got != tt.want {
+			t.Errorf("%s picked %d, want %d", tt.quality, got, tt.want)
+		}
+	}
+}
+
+func TestQualityPickNothingFits(t *testing.T) {
+	candidates := [][2]int{{1080, 6000}, {720, 3000}, {720, 2500}}
+	q := Quality{MaxHeight: 480}
+	got := q.Pick(len(candidates), func(i int) int { return candidates[i][0] }, func(i int) float64 { return float64(candidates[i][1]) })
+	if got != 2 {
+		t.Errorf("picked %d, want the smallest candidate 2", got)
+	}
+	if got := q.Pick(0, nil, nil); got != -1 {
+		t.Errorf("picked %d from no candidates, want -1", got)
+	}
+}
@@ -3,11 +3,11 @@
 package plugin
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/snowie2000/livetv/global"
@@ -22,6 +22,10 @@ func (p *RepeaterParser) Host(c *gin.Context, info *model.LiveInfo) error {
 }
 
 func (p *RepeaterParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *RepeaterParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
 	u, err := url.Parse(liveUrl)
 	if err != nil {
 		return nil, err
@@ -34,15 +38,14 @@ func (p *RepeaterParser) Parse(liveUrl string, proxyUrl string, previousExtraInf
 	}
 
 	client := http.Client{
-		Timeout:   time.Second * 10,
 		Transport: global.TransportWithProxy(proxyUrl),
-		Jar:       global.CookieJar,
+		Jar:       cookieJar(ctx),
 	}
-	req, err := http.NewRequest("GET", liveUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	setRequestHeaders(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -52,7 +55,7 @@ func (p *RepeaterParser) Parse(liveUrl string, proxyUrl string, previousExtraInf
 	// the link itself is a valid M3U8
 	if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "mpegurl") {
 		log.Println(liveUrl, "is a valid url")
-		liveUrl, err := bestFromMasterPlaylist(liveUrl, proxyUrl, resp.Body) // extract the best quality live url from the master playlist
+		liveUrl, err := bestFromMasterPlaylist(ctx, liveUrl, proxyUrl, resp.Body) // extract the best quality live url from the master playlist
 		if err == nil {
 			li := &model.LiveInfo{}
 			if !global.IsValidURL(liveUrl) {
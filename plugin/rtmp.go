@@ -1,12 +1,12 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -29,6 +29,11 @@ func (p *RTMPParser) Host(c *gin.Context, info *model.LiveInfo) error {
 	}
 	log.Println("Start transcoding", info.LiveUrl)
 	defer conn.Close()
+	// stop pulling the stream as soon as the client goes away
+	stop := context.AfterFunc(c.Request.Context(), func() {
+		conn.Close()
+	})
+	defer stop()
 	defer log.Println("Transcoding finished")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
@@ -52,21 +57,24 @@ func (p *RTMPParser) Host(c *gin.Context, info *model.LiveInfo) error {
 }
 
 func (p *RTMPParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *RTMPParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
 	u, err := url.Parse(liveUrl)
 	if err != nil || !strings.EqualFold(u.Scheme, "rtmp") {
 		client := http.Client{
-			Timeout:   time.Second * 10,
 			Transport: global.TransportWithProxy(proxyUrl),
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
-			Jar: global.CookieJar,
+			Jar: cookieJar(ctx),
 		}
-		req, err := http.NewRequest("GET", liveUrl, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set("User-Agent", DefaultUserAgent)
+		setRequestHeaders(req)
 		resp, err := client.Do(req)
 		if err != nil {
 			return nil, err
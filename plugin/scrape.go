@@ -0,0 +1,229 @@
+// scrape
+// a declarative parser: fetch pages in order and extract values with regex / jsonpath rules
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/util"
+)
+
+// Exactly one of Regex, JSONPath, Header or Value should be set.
+// Templates like "{name}" are replaced with the values extracted so far, "{url}" is the channel url.
+type ScrapeRule struct {
+	Name     string `json:"name"`
+	Regex    string `json:"regex"`    // regex on the response body
+	Group    int    `json:"group"`    // capture group of the regex, the first group by default
+	JSONPath string `json:"jsonpath"` // jsonpath on the response body, like $.data.urls[0]
+	Header   string `json:"header"`   // response header
+	Value    string `json:"value"`    // template made of earlier values
+}
+
+type ScrapeStep struct {
+	URL     string            `json:"url"` // "{url}" if empty
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Extract []ScrapeRule      `json:"extract"`
+}
+
+type ScrapeConfig struct {
+	Steps   []ScrapeStep      `json:"steps"`
+	Stream  string            `json:"stream"` // template of the final stream url, "{stream}" if empty
+	Logo    string            `json:"logo"`
+	Headers map[string]string `json:"headers"` // used to request the playlist and the segments later
+}
+
+type ScrapeParser struct {
+	DirectM3U8Parser
+}
+
+func parseScrapeConfig(config string) (*ScrapeConfig, error) {
+	if strings.TrimSpace(config) == "" {
+		return nil, errors.New("scrape parser needs a config")
+	}
+	var cfg ScrapeConfig
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Steps) == 0 {
+		return nil, errors.New("scrape config has no steps")
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = "{stream}"
+	}
+	return &cfg, nil
+}
+
+func (p *ScrapeParser) ValidateConfig(config string) error {
+	cfg, err := parseScrapeConfig(config)
+	if err != nil {
+		return err
+	}
+	for _, step := range cfg.Steps {
+		for _, rule := range step.Extract {
+			if rule.Name == "" {
+				return errors.New("extract rules must have a name")
+			}
+			if rule.Regex != "" {
+				if _, err := regexp2.Compile(rule.Regex, 0); err != nil {
+					return err
+				}
+			}
+			if rule.JSONPath != "" {
+				if _, err := util.JSONPath("{}", rule.JSONPath); err != nil && !strings.Contains(err.Error(), "not found") {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func expandTemplate(tpl string, vars map[string]string) string {
+	pairs := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(tpl)
+}
+
+// values in html are often json escaped, like https:\/\/example.com\/live.m3u8
+func unescapeJSONString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var unquoted string
+	if json.Unmarshal([]byte(`"`+s+`"`), &unquoted) == nil {
+		return unquoted
+	}
+	return s
+}
+
+func applyScrapeRule(rule ScrapeRule, body string, header http.Header, vars map[string]string) (string, error) {
+	switch {
+	case rule.Regex != "":
+		re, err := regexp2.Compile(rule.Regex, 0)
+		if err != nil {
+			return "", err
+		}
+		m, _ := re.FindStringMatch(body)
+		if m == nil {
+			return "", errors.New("no match")
+		}
+		group := rule.Group
+		if group == 0 && len(m.Groups()) > 1 {
+			group = 1
+		}
+		if group >= len(m.Groups()) {
+			return "", fmt.Errorf("no group %d", group)
+		}
+		return unescapeJSONString(m.Groups()[group].String()), nil
+	case rule.JSONPath != "":
+		return util.JSONPath(body, rule.JSONPath)
+	case rule.Header != "":
+		if v := header.Get(rule.Header); v != "" {
+			return v, nil
+		}
+		return "", errors.New("header not found")
+	case rule.Value != "":
+		return expandTemplate(rule.Value, vars), nil
+	}
+	return "", errors.New("empty rule")
+}
+
+func (p *ScrapeParser) runStep(ctx context.Context, step ScrapeStep, proxyUrl string, vars map[string]string) error {
+	target := step.URL
+	if target == "" {
+		target = "{url}"
+	}
+	target = expandTemplate(target, vars)
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(expandTemplate(step.Body, vars))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	setRequestHeaders(req)
+	for k, v := range step.Headers {
+		req.Header.Set(k, expandTemplate(v, vars))
+	}
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer global.CloseBody(resp)
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return err
+	}
+	for _, rule := range step.Extract {
+		v, err := applyScrapeRule(rule, string(content), resp.Header, vars)
+		if err != nil {
+			return fmt.Errorf("%s: rule %s failed: %w", target, rule.Name, err)
+		}
+		vars[rule.Name] = v
+	}
+	return nil
+}
+
+func (p *ScrapeParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return nil, errors.New("scrape parser needs a channel config")
+}
+
+func (p *ScrapeParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	config := ""
+	if ch := ChannelFromContext(ctx); ch != nil {
+		config = ch.ParserConfig
+	}
+	cfg, err := parseScrapeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{"url": liveUrl}
+	for _, step := range cfg.Steps {
+		if err := p.runStep(ctx, step, proxyUrl, vars); err != nil {
+			return nil, err
+		}
+	}
+	stream := expandTemplate(cfg.Stream, vars)
+	if !global.IsValidURL(stream) {
+		return nil, NoMatchFeed
+	}
+	ui := UrlInfo{
+		Headers: make(map[string]string),
+		Logo:    expandTemplate(cfg.Logo, vars),
+	}
+	for k, v := range cfg.Headers {
+		ui.Headers[k] = expandTemplate(v, vars)
+	}
+	js, _ := json.Marshal(ui)
+	li := &model.LiveInfo{}
+	li.LiveUrl = stream
+	li.Logo = ui.Logo
+	li.ExtraInfo = string(js)
+	return li, nil
+}
+
+func init() {
+	registerPlugin("scrape", &ScrapeParser{}, 8)
+}
@@ -0,0 +1,231 @@
+// script
+// parsers written in javascript by admins and stored in the database
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	"github.com/dop251/goja"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+// A script must define a parse function:
+//
+//	function parse(url, extra) {
+//		var resp = fetch(url, {headers: {"Referer": "https://example.com"}})
+//		var m = match('"hls":"(.+?)"', resp.body)
+//		return {url: m[1], logo: "", extra: {}, expires: 0}
+//	}
+//
+// returning null means the channel is not live.
+type ScriptParser struct {
+	name    string
+	program *goja.Program
+}
+
+const (
+	maxScriptFetchSize = 10 * 1024 * 1024
+	// the top level of a script only defines functions, it mustn't hang the server when the script is loaded
+	scriptLoadTimeout = 5 * time.Second
+)
+
+func NewScriptParser(name string, code string) (*ScriptParser, error) {
+	program, err := goja.Compile(name, code, true)
+	if err != nil {
+		return nil, err
+	}
+	vm := goja.New()
+	timer := time.AfterFunc(scriptLoadTimeout, func() {
+		vm.Interrupt("script took longer than " + scriptLoadTimeout.String() + " to load")
+	})
+	defer timer.Stop()
+	if _, err = vm.RunProgram(program); err != nil {
+		return nil, err
+	}
+	if _, ok := goja.AssertFunction(vm.Get("parse")); !ok {
+		return nil, errors.New("script does not define a parse function")
+	}
+	return &ScriptParser{name: name, program: program}, nil
+}
+
+func (p *ScriptParser) throw(vm *goja.Runtime, err error) {
+	panic(vm.NewGoError(err))
+}
+
+// fetch(url, {method, headers, body}) => {status, url, headers, body}
+func (p *ScriptParser) fetch(ctx context.Context, vm *goja.Runtime, proxyUrl string) func(string, map[string]any) map[string]any {
+	return func(target string, options map[string]any) map[string]any {
+		method := http.MethodGet
+		var body io.Reader
+		if m, ok := options["method"].(string); ok && m != "" {
+			method = strings.ToUpper(m)
+		}
+		if b, ok := options["body"].(string); ok {
+			body = strings.NewReader(b)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, body)
+		if err != nil {
+			p.throw(vm, err)
+		}
+		setRequestHeaders(req)
+		if headers, ok := options["headers"].(map[string]any); ok {
+			for k, v := range headers {
+				req.Header.Set(k, fmt.Sprint(v))
+			}
+		}
+		client := http.Client{
+			Transport: global.TransportWithProxy(proxyUrl),
+			Jar:       cookieJar(ctx),
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			p.throw(vm, err)
+		}
+		defer global.CloseBody(resp)
+		content, err := io.ReadAll(io.LimitReader(resp.Body, maxScriptFetchSize))
+		if err != nil {
+			p.throw(vm, err)
+		}
+		headers := make(map[string]any)
+		for k := range resp.Header {
+			headers[strings.ToLower(k)] = resp.Header.Get(k)
+		}
+		return map[string]any{
+			"status":  resp.StatusCode,
+			"url":     resp.Request.URL.String(),
+			"headers": headers,
+			"body":    string(content),
+		}
+	}
+}
+
+func captures(m *regexp2.Match) []any {
+	groups := []any{}
+	for _, g := range m.Groups() {
+		if len(g.Captures) > 0 {
+			groups = append(groups, g.String())
+		} else {
+			groups = append(groups, nil)
+		}
+	}
+	return groups
+}
+
+// match(pattern, text) => [whole, group1, ...] or null
+func (p *ScriptParser) match(vm *goja.Runtime) func(string, string) any {
+	return func(pattern string, text string) any {
+		re, err := regexp2.Compile(pattern, 0)
+		if err != nil {
+			p.throw(vm, err)
+		}
+		m, _ := re.FindStringMatch(text)
+		if m == nil {
+			return nil
+		}
+		return captures(m)
+	}
+}
+
+// matchAll(pattern, text) => [[whole, group1, ...], ...]
+func (p *ScriptParser) matchAll(vm *goja.Runtime) func(string, string) []any {
+	return func(pattern string, text string) []any {
+		re, err := regexp2.Compile(pattern, 0)
+		if err != nil {
+			p.throw(vm, err)
+		}
+		all := []any{}
+		m, _ := re.FindStringMatch(text)
+		for m != nil {
+			all = append(all, captures(m))
+			m, _ = re.FindNextMatch(m)
+		}
+		return all
+	}
+}
+
+func (p *ScriptParser) newRuntime(ctx context.Context, proxyUrl string) *goja.Runtime {
+	vm := goja.New()
+	vm.Set("fetch", p.fetch(ctx, vm, proxyUrl))
+	vm.Set("match", p.match(vm))
+	vm.Set("matchAll", p.matchAll(vm))
+	vm.Set("log", func(args ...any) {
+		log.Println(append([]any{"[" + p.name + "]"}, args...)...)
+	})
+	return vm
+}
+
+func (p *ScriptParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *ScriptParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	vm := p.newRuntime(ctx, proxyUrl)
+	stop := context.AfterFunc(ctx, func() {
+		vm.Interrupt(ctx.Err())
+	})
+	defer stop()
+	if _, err := vm.RunProgram(p.program); err != nil {
+		return nil, err
+	}
+	parse, ok := goja.AssertFunction(vm.Get("parse"))
+	if !ok {
+		return nil, errors.New("script does not define a parse function")
+	}
+	v, err := parse(goja.Undefined(), vm.ToValue(liveUrl), vm.ToValue(previousExtraInfo))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil, NoMatchFeed
+	}
+	result, ok := v.Export().(map[string]any)
+	if !ok {
+		return nil, errors.New("parse must return an object")
+	}
+	li := &model.LiveInfo{}
+	li.LiveUrl, _ = result["url"].(string)
+	li.Logo, _ = result["logo"].(string)
+	switch extra := result["extra"].(type) {
+	case nil:
+	case string:
+		li.ExtraInfo = extra
+	default:
+		js, _ := json.Marshal(extra)
+		li.ExtraInfo = string(js)
+	}
+	switch expires := result["expires"].(type) {
+	case int64:
+		if expires > 0 {
+			li.ExpiresAt = time.Unix(expires, 0)
+		}
+	case float64:
+		if expires > 0 {
+			li.ExpiresAt = time.Unix(int64(expires), 0)
+		}
+	}
+	if li.LiveUrl == "" {
+		return nil, NoMatchFeed
+	}
+	return li, nil
+}
+
+func (p *ScriptParser) dynamic() string { return ScriptKind }
+
+// RegisterScript compiles a script and registers it as a parser named after the script
+func RegisterScript(name string, code string, priority int) error {
+	p, err := NewScriptParser(name, code)
+	if err != nil {
+		return err
+	}
+	return registerDynamic(name, p, priority)
+}
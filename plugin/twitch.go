@@ -0,0 +1,223 @@
+// twitch
+// gets a playback token from the gql api and opens the usher hls playlist with it
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	twitchClientID = "kimne78kx3ncx6brgo4mv6wki5h1ko" // the public client id of the twitch website
+	twitchGQL      = "https://gql.twitch.tv/gql"
+	twitchUsher    = "https://usher.ttvnw.net"
+
+	twitchTokenQuery = `query PlaybackAccessToken_Template($login: String!, $isLive: Boolean!, $vodID: ID!, $isVod: Boolean!, $playerType: String!) {
+  streamPlaybackAccessToken(channelName: $login, params: {platform: "web", playerBackend: "mediaplayer", playerType: $playerType}) @include(if: $isLive) { value signature }
+  videoPlaybackAccessToken(id: $vodID, params: {platform: "web", playerBackend: "mediaplayer", playerType: $playerType}) @include(if: $isVod) { value signature }
+}`
+	twitchInfoQuery = `query ChannelInfo($login: String!) {
+  user(login: $login) { profileImageURL(width: 300) broadcastSettings { title } stream { id } }
+}`
+)
+
+var twitchLoginRegex = regexp.MustCompile(`twitch\.tv/([A-Za-z0-9_]+)`)
+
+type TwitchParser struct{}
+
+type twitchGQLRequest struct {
+	OperationName string         `json:"operationName"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type twitchTokenResponse struct {
+	Data struct {
+		StreamPlaybackAccessToken *struct {
+			Value     string `json:"value"`
+			Signature string `json:"signature"`
+		} `json:"streamPlaybackAccessToken"`
+	} `json:"data"`
+}
+
+type twitchInfoResponse struct {
+	Data struct {
+		User *struct {
+			ProfileImageURL   string `json:"profileImageURL"`
+			BroadcastSettings struct {
+				Title string `json:"title"`
+			} `json:"broadcastSettings"`
+			Stream *struct {
+				ID string `json:"id"`
+			} `json:"stream"`
+		} `json:"user"`
+	} `json:"data"`
+}
+
+// the token value is a json document
+type twitchToken struct {
+	Expires int64 `json:"expires"`
+}
+
+// the endpoints can be pointed to a local server
+func twitchEndpoint(env string, def string) string {
+	if v := os.Getenv(env); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return def
+}
+
+func twitchLogin(liveUrl string) string {
+	if m := twitchLoginRegex.FindStringSubmatch(liveUrl); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// the token and the channel info are fetched in one batch
+func (p *TwitchParser) queryGQL(ctx context.Context, login string, proxyUrl string) (*twitchTokenResponse, *twitchInfoResponse, error) {
+	body, _ := json.Marshal([]twitchGQLRequest{
+		{
+			OperationName: "PlaybackAccessToken_Template",
+			Query:         twitchTokenQuery,
+			Variables:     map[string]any{"login": login, "isLive": true, "isVod": false, "vodID": "", "playerType": "site"},
+		},
+		{
+			OperationName: "ChannelInfo",
+			Query:         twitchInfoQuery,
+			Variables:     map[string]any{"login": login},
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, twitchEndpoint("LIVETV_TWITCH_GQL", twitchGQL), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Client-ID", twitchClientID)
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	setRequestHeaders(req) // an "Authorization: OAuth ..." channel header gives access to subscriber only streams
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer global.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("twitch gql: HTTP %d", resp.StatusCode)
+	}
+	var results []json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&results); err != nil {
+		return nil, nil, err
+	}
+	if len(results) != 2 {
+		return nil, nil, errors.New("twitch gql: unexpected response")
+	}
+	var token twitchTokenResponse
+	var info twitchInfoResponse
+	if err := json.Unmarshal(results[0], &token); err != nil {
+		return nil, nil, err
+	}
+	json.Unmarshal(results[1], &info)
+	return &token, &info, nil
+}
+
+func (p *TwitchParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *TwitchParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	login := twitchLogin(liveUrl)
+	if login == "" {
+		return nil, errors.New("not a twitch channel url")
+	}
+	token, info, err := p.queryGQL(ctx, login, proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	if info.Data.User == nil {
+		return nil, fmt.Errorf("twitch channel %s not found", login)
+	}
+	if info.Data.User.Stream == nil || token.Data.StreamPlaybackAccessToken == nil {
+		return nil, NoMatchFeed
+	}
+	q := url.Values{}
+	q.Set("token", token.Data.StreamPlaybackAccessToken.Value)
+	q.Set("sig", token.Data.StreamPlaybackAccessToken.Signature)
+	q.Set("allow_source", "true")
+	q.Set("allow_audio_only", "true")
+	q.Set("fast_bread", "true")
+	q.Set("player_backend", "mediaplayer")
+	q.Set("playlist_include_framerate", "true")
+	q.Set("p", fmt.Sprint(rand.Intn(10000000)))
+	usherUrl := twitchEndpoint("LIVETV_TWITCH_USHER", twitchUsher) + "/api/channel/hls/" + login + ".m3u8?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, usherUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req)
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer global.CloseBody(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NoMatchFeed // went offline between the two requests
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("twitch usher: HTTP %d", resp.StatusCode)
+	}
+	bestUrl, err := bestFromMasterPlaylist(ctx, usherUrl, proxyUrl, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	li := &model.LiveInfo{
+		LiveUrl: bestUrl,
+		Logo:    info.Data.User.ProfileImageURL,
+		Title:   info.Data.User.BroadcastSettings.Title,
+	}
+	var t twitchToken
+	if json.Unmarshal([]byte(token.Data.StreamPlaybackAccessToken.Value), &t) == nil && t.Expires > 0 {
+		li.ExpiresAt = time.Unix(t.Expires, 0)
+	}
+	return li, nil
+}
+
+// offline streams end their playlist. Ad breaks are not a reason to reparse, a new token gets the same ads,
+// they are only reported as a warning.
+func (p *TwitchParser) Check(content string, info *model.LiveInfo) error {
+	if strings.Contains(content, "#EXT-X-ENDLIST") {
+		return errors.New("live ended")
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "#EXT-X-DATERANGE:") && strings.Contains(line, `CLASS="twitch-stitched-ad"`) ||
+			strings.HasPrefix(line, "#EXTINF:") && strings.Contains(line, "stitched-ad") {
+			return HealthWarning("ad break")
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerPlugin("twitch", &TwitchParser{}, 10)
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a stand-in for the gql api and usher, live tells whether the channel is streaming
+func twitchServer(t *testing.T, live bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gql", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Client-ID") != twitchClientID {
+			http.Error(w, "missing client id", http.StatusBadRequest)
+			return
+		}
+		var reqs []twitchGQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil || len(reqs) != 2 || reqs[1].Variables["login"] != "somebody" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		token, stream := "null", "null"
+		if live {
+			value, _ := json.Marshal(fmt.Sprintf(`{"expires":%d}`, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix()))
+			token = fmt.Sprintf(`{"value":%s,"signature":"sig"}`, value)
+			stream = `{"id":"1"}`
+		}
+		fmt.Fprintf(w, `[{"data":{"streamPlaybackAccessToken":%s}},{"data":{"user":{"profileImageURL":"https://example.com/logo.png","broadcastSettings":{"title":"Just chatting"},"stream":%s}}}]`, token, stream)
+	})
+	mux.HandleFunc("/api/channel/hls/somebody.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "sig" {
+			http.Error(w, "bad token", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprint(w, "#EXTM3U\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=1500000,RESOLUTION=852x480\n480p.m3u8\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080\n1080p.m3u8\n")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Setenv("LIVETV_TWITCH_GQL", srv.URL+"/gql")
+	t.Setenv("LIVETV_TWITCH_USHER", srv.URL)
+	return srv
+}
+
+func TestTwitchParse(t *testing.T) {
+	srv := twitchServer(t, true)
+	li, err := (&TwitchParser{}).ParseContext(context.Background(), "https://www.twitch.tv/SomeBody", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/api/channel/hls/1080p.m3u8"; li.LiveUrl != want {
+		t.Errorf("live url %q, want %q", li.LiveUrl, want)
+	}
+	if li.Title != "Just chatting" || li.Logo != "https://example.com/logo.png" {
+		t.Errorf("title %q, logo %q", li.Title, li.Logo)
+	}
+	if li.ExpiresAt.Year() != 2030 {
+		t.Errorf("expires at %v, want the expiry of the token", li.ExpiresAt)
+	}
+}
+
+func TestTwitchOffline(t *testing.T) {
+	twitchServer(t, false)
+	_, err := (&TwitchParser{}).ParseContext(context.Background(), "https://www.twitch.tv/somebody", "", "")
+	if !errors.Is(err, NoMatchFeed) {
+		t.Fatalf("got %v, want NoMatchFeed", err)
+	}
+}
+
+func TestTwitchCheck(t *testing.T) {
+	p := &TwitchParser{}
+	tests := []struct {
+		name    string
+		content string
+		warning bool
+	}{
+		{"daterange", "#EXTM3U\n#EXT-X-DATERANGE:ID=\"ad-1\",CLASS=\"twitch-stitched-ad\",START-DATE=\"2026-01-01T00:00:00Z\"\n#EXTINF:2.000,Amazon\nad.ts\n#EXTINF:2.000,live\nseg.ts\n", true},
+		{"segment title", "#EXTM3U\n#EXTINF:2.000,stitched-ad-1-0\nad.ts\n", true},
+		{"live", "#EXTM3U\n#EXT-X-DATERANGE:ID=\"source-1\",CLASS=\"twitch-session\"\n#EXTINF:2.000,live\nseg.ts\n", false},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.content, nil)
+		var warning HealthWarning
+		if tt.warning != errors.As(err, &warning) || !tt.warning && err != nil {
+			t.Errorf("%s: got %v, want an ad break warning: %v", tt.name, err, tt.warning)
+		}
+	}
+	err := p.Check("#EXTM3U\n#EXTINF:2.000,live\nseg.ts\n#EXT-X-ENDLIST\n", nil)
+	var warning HealthWarning
+	if err == nil || errors.As(err, &warning) {
+		t.Errorf("an ended playlist is unhealthy, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+// udp
+// serves MPEG-TS from udp multicast or unicast sources to http clients, like udpxy
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	udpReadTimeout      = 10 * time.Second // a source silent for this long is considered gone
+	udpSubscriberBuffer = 1024             // datagrams a slow client may fall behind before it's dropped
+)
+
+type UDPParser struct{}
+
+// one socket shared by every client watching the same source
+type udpFeed struct {
+	key         string
+	conn        *net.UDPConn
+	rtp         bool
+	mu          sync.Mutex
+	subscribers map[chan []byte]bool
+}
+
+var (
+	udpFeedsLock sync.Mutex
+	udpFeeds     = make(map[string]*udpFeed)
+)
+
+// udp://239.1.1.1:1234 joins a multicast group, udp://@:1234 or udp://0.0.0.0:1234 listens on a unicast port.
+// rtp:// is the same with rtp headers, ?iface=eth1 (a name or an address) picks the interface.
+func parseUDPUrl(liveUrl string) (addr *net.UDPAddr, ifi *net.Interface, rtp bool, err error) {
+	u, err := url.Parse(liveUrl)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "udp":
+	case "rtp":
+		rtp = true
+	default:
+		return nil, nil, false, errors.New("not a udp or rtp url")
+	}
+	// the "@" of udp://@:1234 ends up as an empty user
+	ip, sPort, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	port, err := strconv.Atoi(sPort)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, nil, false, fmt.Errorf("invalid port %s", sPort)
+	}
+	addr = &net.UDPAddr{IP: net.ParseIP(ip), Port: port}
+	if ip != "" && addr.IP == nil {
+		return nil, nil, false, fmt.Errorf("invalid address %s", ip)
+	}
+	if name := u.Query().Get("iface"); name != "" {
+		if ifi, err = udpInterface(name); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	return addr, ifi, rtp, nil
+}
+
+// find an interface by its name or one of its addresses
+func udpInterface(name string) (*net.Interface, error) {
+	if ifi, err := net.InterfaceByName(name); err == nil {
+		return ifi, nil
+	}
+	ip := net.ParseIP(name)
+	if ip != nil {
+		ifaces, _ := net.Interfaces()
+		for i := range ifaces {
+			addrs, _ := ifaces[i].Addrs()
+			for _, a := range addrs {
+				if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+					return &ifaces[i], nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+// the first ipv4 address of an interface, unicast sockets are bound to it
+func interfaceIP(ifi *net.Interface) net.IP {
+	addrs, _ := ifi.Addrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP
+		}
+	}
+	return nil
+}
+
+func openUDPFeed(liveUrl string) (*udpFeed, error) {
+	addr, ifi, rtp, err := parseUDPUrl(liveUrl)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if addr.IP != nil && addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", ifi, addr)
+	} else {
+		if ifi != nil && (addr.IP == nil || addr.IP.IsUnspecified()) {
+			addr.IP = interfaceIP(ifi)
+		}
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(4 * 1024 * 1024)
+	feed := &udpFeed{
+		key:         liveUrl,
+		conn:        conn,
+		rtp:         rtp,
+		subscribers: make(map[chan []byte]bool),
+	}
+	go feed.run()
+	log.Println("udp feed opened", liveUrl)
+	return feed, nil
+}
+
+// the rtp payload of a packet, packets without an rtp header are returned as they are
+func stripRTP(b []byte) []byte {
+	if len(b) < 12 || b[0] == 0x47 || b[0]>>6 != 2 {
+		return b
+	}
+	// fixed header, csrc list and header extension
+	offset := 12 + int(b[0]&0x0f)*4
+	if b[0]&0x10 != 0 && len(b) >= offset+4 {
+		offset += 4 + (int(b[offset+2])<<8|int(b[offset+3]))*4
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 { // padding
+		end -= int(b[len(b)-1])
+	}
+	if offset >= end {
+		return nil
+	}
+	return b[offset:end]
+}
+
+func (f *udpFeed) run() {
+	defer f.close()
+	buf := make([]byte, 65536)
+	for {
+		f.conn.SetReadDeadline(time.Now().Add(udpReadTimeout))
+		n, _, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("udp feed", f.key, "stopped:", err)
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		data := buf[:n]
+		if f.rtp || data[0] != 0x47 {
+			data = stripRTP(data)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		packet := make([]byte, len(data))
+		copy(packet, data)
+		f.mu.Lock()
+		for sub := range f.subscribers {
+			select {
+			case sub <- packet:
+			default:
+				// the client can't keep up, drop it rather than sending it a broken stream
+				delete(f.subscribers, sub)
+				close(sub)
+			}
+		}
+		f.mu.Unlock()
+	}
+}
+
+// close the socket and end every subscriber
+func (f *udpFeed) close() {
+	udpFeedsLock.Lock()
+	if udpFeeds[f.key] == f {
+		delete(udpFeeds, f.key)
+	}
+	udpFeedsLock.Unlock()
+	f.conn.Close()
+	f.mu.Lock()
+	for sub := range f.subscribers {
+		close(sub)
+	}
+	f.subscribers = nil
+	f.mu.Unlock()
+}
+
+// subscribe to a source, opening its socket for the first viewer
+func subscribeUDP(liveUrl string) (*udpFeed, chan []byte, error) {
+	udpFeedsLock.Lock()
+	defer udpFeedsLock.Unlock()
+	feed, ok := udpFeeds[liveUrl]
+	if !ok {
+		var err error
+		if feed, err = openUDPFeed(liveUrl); err != nil {
+			return nil, nil, err
+		}
+		udpFeeds[liveUrl] = feed
+	}
+	sub := make(chan []byte, udpSubscriberBuffer)
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if feed.subscribers == nil {
+		return nil, nil, errors.New("udp feed is closing")
+	}
+	feed.subscribers[sub] = true
+	return feed, sub, nil
+}
+
+// leave a source, the socket is closed with its last viewer
+func (f *udpFeed) unsubscribe(sub chan []byte) {
+	udpFeedsLock.Lock()
+	defer udpFeedsLock.Unlock()
+	f.mu.Lock()
+	if f.subscribers[sub] {
+		delete(f.subscribers, sub)
+		close(sub)
+	}
+	last := f.subscribers != nil && len(f.subscribers) == 0
+	f.mu.Unlock()
+	if last {
+		if udpFeeds[f.key] == f {
+			delete(udpFeeds, f.key)
+		}
+		f.conn.Close()
+		log.Println("udp feed closed", f.key)
+	}
+}
+
+func (p *UDPParser) Host(c *gin.Context, info *model.LiveInfo) error {
+	feed, sub, err := subscribeUDP(info.LiveUrl)
+	if err != nil {
+		return err
+	}
+	defer feed.unsubscribe(sub)
+	// wait for data before answering, so that a dead source is reported as an error
+	var packet []byte
+	var ok bool
+	select {
+	case packet, ok = <-sub:
+		if !ok {
+			return errors.New("udp source stopped")
+		}
+	case <-time.After(udpReadTimeout):
+		return errors.New("no data from udp source")
+	case <-c.Request.Context().Done():
+		return nil
+	}
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "*")
+	c.Writer.Header().Set("Content-Type", "video/mp2t")
+	c.Writer.WriteHeader(200)
+	for ok {
+		if _, err := c.Writer.Write(packet); err != nil {
+			break
+		}
+		// flush once the queue is drained, not for every datagram
+		if len(sub) == 0 {
+			c.Writer.Flush()
+		}
+		select {
+		case packet, ok = <-sub:
+		case <-c.Request.Context().Done():
+			ok = false
+		}
+	}
+	return nil
+}
+
+func (p *UDPParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return p.ParseContext(context.Background(), liveUrl, proxyUrl, previousExtraInfo)
+}
+
+// there is nothing to resolve, the url is only checked
+func (p *UDPParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	if _, _, _, err := parseUDPUrl(liveUrl); err != nil {
+		return nil, err
+	}
+	return &model.LiveInfo{LiveUrl: liveUrl}, nil
+}
+
+func init() {
+	registerPlugin("udp", &UDPParser{}, 11)
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+// a unicast source url on a free local port
+func freeUDPUrl(t *testing.T, scheme string) (string, *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	return fmt.Sprintf("%s://127.0.0.1:%d", scheme, addr.Port), addr
+}
+
+// send datagrams to addr until the test ends
+func sendUDP(t *testing.T, addr *net.UDPAddr, datagram []byte) {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		conn.Close()
+	})
+	go func() {
+		for {
+			conn.Write(datagram)
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+}
+
+func receive(t *testing.T, sub chan []byte) []byte {
+	select {
+	case packet, ok := <-sub:
+		if !ok {
+			t.Fatal("subscriber closed")
+		}
+		return packet
+	case <-time.After(2 * time.Second):
+		t.Fatal("no data from the udp feed")
+	}
+	return nil
+}
+
+func TestUDPFeedSharing(t *testing.T) {
+	liveUrl, addr := freeUDPUrl(t, "udp")
+	feed1, sub1, err := subscribeUDP(liveUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed2, sub2, err := subscribeUDP(liveUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed1 != feed2 {
+		t.Fatal("clients of the same source should share its socket")
+	}
+
+	ts := bytes.Repeat(append([]byte{0x47}, make([]byte, 187)...), 7)
+	sendUDP(t, addr, ts)
+	if got := receive(t, sub1); !bytes.Equal(got, ts) {
+		t.Fatalf("first client got %d bytes, want the datagram", len(got))
+	}
+	if got := receive(t, sub2); !bytes.Equal(got, ts) {
+		t.Fatalf("second client got %d bytes, want the datagram", len(got))
+	}
+
+	// the socket stays open for the remaining client and closes with the last one
+	feed1.unsubscribe(sub1)
+	receive(t, sub2)
+	feed2.unsubscribe(sub2)
+	udpFeedsLock.Lock()
+	_, open := udpFeeds[liveUrl]
+	udpFeedsLock.Unlock()
+	if open {
+		t.Fatal("feed is still registered after its last client left")
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("port is still in use after the last client left: %v", err)
+	}
+	conn.Close()
+}
+
+func TestUDPFeedRTP(t *testing.T) {
+	liveUrl, addr := freeUDPUrl(t, "rtp")
+	feed, sub, err := subscribeUDP(liveUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.unsubscribe(sub)
+	ts := append([]byte{0x47}, make([]byte, 187)...)
+	header := []byte{0x80, 33, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1} // version 2, mpeg-ts payload
+	sendUDP(t, addr, append(header, ts...))
+	if got := receive(t, sub); !bytes.Equal(got, ts) {
+		t.Fatalf("got %d bytes, want the rtp payload", len(got))
+	}
+}
+
+func TestUDPHost(t *testing.T) {
+	liveUrl, addr := freeUDPUrl(t, "udp")
+	ts := bytes.Repeat(append([]byte{0x47}, make([]byte, 187)...), 7)
+	sendUDP(t, addr, ts)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) {
+		(&UDPParser{}).Host(c, &model.LiveInfo{LiveUrl: liveUrl})
+	})
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// two viewers at once, both get the stream
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "video/mp2t" {
+			t.Fatalf("content type %q", ct)
+		}
+		buf := make([]byte, len(ts)*2)
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0] != 0x47 || buf[188] != 0x47 {
+			t.Fatal("the stream is not MPEG-TS")
+		}
+	}
+	udpFeedsLock.Lock()
+	feed := udpFeeds[liveUrl]
+	udpFeedsLock.Unlock()
+	if feed == nil {
+		t.Fatal("no feed while clients are watching")
+	}
+	feed.mu.Lock()
+	viewers := len(feed.subscribers)
+	feed.mu.Unlock()
+	if viewers != 2 {
+		t.Fatalf("%d viewers share the feed, want 2", viewers)
+	}
+}
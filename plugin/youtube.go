@@ -2,13 +2,13 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
@@ -22,17 +22,16 @@ type YoutubeExtraInfo struct {
 	LastUrl string
 }
 
-func isLive(m3u8Url string, proxyUrl string) bool {
+func isLive(ctx context.Context, m3u8Url string, proxyUrl string) bool {
 	client := http.Client{
-		Timeout:   time.Second * 10,
 		Transport: global.TransportWithProxy(proxyUrl),
-		Jar:       global.CookieJar,
+		Jar:       cookieJar(ctx),
 	}
-	req, err := http.NewRequest("GET", m3u8Url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", m3u8Url, nil)
 	if err != nil {
 		return false
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	setRequestHeaders(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return false
@@ -47,17 +46,16 @@ func isLive(m3u8Url string, proxyUrl string) bool {
 	return !strings.Contains(scontent, "EXT-X-ENDLIST")
 }
 
-func parseUrl(liveUrl string, proxyUrl string) (*model.LiveInfo, error) {
+func parseUrl(ctx context.Context, liveUrl string, proxyUrl string) (*model.LiveInfo, error) {
 	client := http.Client{
-		Timeout:   time.Second * 10,
 		Transport: global.TransportWithProxy(proxyUrl),
-		Jar:       global.CookieJar,
+		Jar:       cookieJar(ctx),
 	}
-	req, err := http.NewRequest("GET", liveUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", liveUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	setRequestHeaders(req)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -75,13 +73,13 @@ func parseUrl(liveUrl string, proxyUrl string) (*model.LiveInfo, error) {
 	if matches != nil {
 		gps := matches.Groups()
 		liveMasterUrl := gps[0].Captures[0].String()
-		liveUrl, err := bestFromMasterPlaylist(liveMasterUrl, proxyUrl) // extract the best quality live url from the master playlist
+		liveUrl, err := bestFromMasterPlaylist(ctx, liveMasterUrl, proxyUrl) // extract the best quality live url from the master playlist
 		if err != nil {
 			return nil, err
 		}
 
 		// check if the live feed is still streaming
-		if !isLive(liveUrl, proxyUrl) {
+		if !isLive(ctx, liveUrl, proxyUrl) {
 			return nil, errors.New("No longer streaming")
 		}
 
@@ -116,17 +114,42 @@ func (p *YoutubeParser) Check(content string, info *model.LiveInfo) error {
 	return nil
 }
 
+// youtube manifest urls expire in a few hours
+func (p *YoutubeParser) Schedule() string {
+	return "@every 1h"
+}
+
 func (p *YoutubeParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *YoutubeParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
 	var info YoutubeExtraInfo
 	json.Unmarshal([]byte(previousExtraInfo), &info)
+	if getYouTubeVideoID(liveUrl) == "" {
+		// channel urls are resolved through the data api when possible, it's more reliable than scraping
+		videoID, err := youtubeLiveVideoID(ctx, liveUrl, proxyUrl)
+		switch {
+		case err == nil:
+			li, err := parseUrl(ctx, "https://www.youtube.com/watch?v="+videoID, proxyUrl)
+			if err != nil {
+				forgetYoutubeLiveVideo(videoID)
+			}
+			return li, err
+		case errors.Is(err, NoMatchFeed):
+			return nil, err
+		case !errors.Is(err, errYoutubeAPIUnavailable):
+			log.Println("youtube api failed, falling back to scraping:", err)
+		}
+	}
 	// for generic urls like "youtube.com/@channel/live", we try last url first, then the generic url
 	if getYouTubeVideoID(liveUrl) == "" && info.LastUrl != "" {
-		if li, err := parseUrl(info.LastUrl, proxyUrl); err == nil {
+		if li, err := parseUrl(ctx, info.LastUrl, proxyUrl); err == nil {
 			log.Println("Reused last url for video interpretation:", info.LastUrl)
 			return li, err
 		}
 	}
-	return parseUrl(liveUrl, proxyUrl)
+	return parseUrl(ctx, liveUrl, proxyUrl)
 }
 
 func init() {
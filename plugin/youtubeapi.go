@@ -0,0 +1,245 @@
+// youtube data api
+// resolves channel, handle and user urls to the current live video, scraping is used when the api is not available
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/syncx"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	youtubeListCost   = 1   // channels.list
+	youtubeSearchCost = 100 // search.list
+
+	youtubeLiveCacheTTL    = 10 * time.Minute
+	youtubeOfflineCacheTTL = 5 * time.Minute
+)
+
+type youtubeQuota struct {
+	mu        sync.Mutex
+	day       string
+	used      int
+	exhausted bool // google told us the quota is gone before our count did
+}
+
+type youtubeLiveEntry struct {
+	videoID string // empty when the channel is offline
+	expires time.Time
+}
+
+var (
+	ytQuota      youtubeQuota
+	ytChannelIDs syncx.Map[string, string]           // "@handle" or "user/name" => channel id, they never change
+	ytLiveVideos syncx.Map[string, youtubeLiveEntry] // channel id => live video
+
+	errYoutubeAPIUnavailable = errors.New("youtube api is not available")
+	ytChannelUrlRegex        = regexp.MustCompile(`youtube\.com/(channel/|user/|c/|@)([^/?#]+)`)
+)
+
+// the quota resets at midnight pacific time
+func youtubeQuotaDay() string {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		loc = time.FixedZone("PST", -8*3600)
+	}
+	return time.Now().In(loc).Format("2006-01-02")
+}
+
+func youtubeQuotaLimit() int {
+	s, _ := global.GetConfig("youtube_quota")
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return 10000
+	}
+	return limit
+}
+
+// reserve units from today's quota, false if there is not enough left
+func (q *youtubeQuota) take(cost int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if day := youtubeQuotaDay(); day != q.day {
+		q.day, q.used, q.exhausted = day, 0, false
+	}
+	if q.exhausted || q.used+cost > youtubeQuotaLimit() {
+		return false
+	}
+	q.used += cost
+	return true
+}
+
+func (q *youtubeQuota) exhaust() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.exhausted = true
+}
+
+// YoutubeQuotaUsed returns the data api units used today and the daily limit
+func YoutubeQuotaUsed() (used int, limit int) {
+	ytQuota.mu.Lock()
+	defer ytQuota.mu.Unlock()
+	if ytQuota.day == youtubeQuotaDay() {
+		used = ytQuota.used
+	}
+	return used, youtubeQuotaLimit()
+}
+
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if strings.Contains(item.Reason, "quotaExceeded") || strings.Contains(item.Reason, "dailyLimitExceeded") || strings.Contains(item.Reason, "rateLimitExceeded") {
+			return true
+		}
+	}
+	return false
+}
+
+// option.WithHTTPClient drops option.WithAPIKey, so the key is added here
+type apiKeyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set("key", t.key)
+	req.URL.RawQuery = q.Encode()
+	return t.base.RoundTrip(req)
+}
+
+func youtubeService(ctx context.Context, proxyUrl string) (*youtube.Service, error) {
+	apiKey, _ := global.GetConfig("apiKey")
+	if apiKey == "" {
+		return nil, errYoutubeAPIUnavailable
+	}
+	opts := []option.ClientOption{
+		option.WithHTTPClient(&http.Client{
+			Transport: &apiKeyTransport{key: apiKey, base: global.TransportWithProxy(proxyUrl)},
+		}),
+	}
+	// allows pointing to a local fake server
+	if endpoint := os.Getenv("LIVETV_YOUTUBE_API"); endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	return youtube.NewService(ctx, opts...)
+}
+
+// run an api call if the quota allows
+func youtubeCall(cost int, call func() error) error {
+	if !ytQuota.take(cost) {
+		return errYoutubeAPIUnavailable
+	}
+	err := call()
+	if isQuotaError(err) {
+		log.Println("youtube api quota exceeded, falling back to scraping")
+		ytQuota.exhaust()
+		return errYoutubeAPIUnavailable
+	}
+	return err
+}
+
+// resolve a channel url to its channel id, custom /c/ urls are not supported by the api
+func youtubeChannelID(ctx context.Context, service *youtube.Service, liveUrl string) (string, error) {
+	m := ytChannelUrlRegex.FindStringSubmatch(liveUrl)
+	if m == nil {
+		return "", errYoutubeAPIUnavailable // not a channel url, leave it to the scraper
+	}
+	kind := m[1]
+	name, err := url.PathUnescape(m[2])
+	if err != nil {
+		name = m[2]
+	}
+	switch kind {
+	case "channel/":
+		return name, nil
+	case "c/":
+		return "", errYoutubeAPIUnavailable
+	}
+	key := kind + name
+	if id, ok := ytChannelIDs.Load(key); ok {
+		return id, nil
+	}
+	var resp *youtube.ChannelListResponse
+	err = youtubeCall(youtubeListCost, func() (err error) {
+		call := service.Channels.List([]string{"id"}).Context(ctx)
+		if kind == "@" {
+			call = call.ForHandle("@" + name)
+		} else {
+			call = call.ForUsername(name)
+		}
+		resp, err = call.Do()
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Items) == 0 {
+		return "", fmt.Errorf("youtube channel %s%s not found", kind, name)
+	}
+	ytChannelIDs.Store(key, resp.Items[0].Id)
+	return resp.Items[0].Id, nil
+}
+
+// youtubeLiveVideoID finds the live video of a channel url through the data api.
+// It returns errYoutubeAPIUnavailable when there is no api key or not enough quota, and NoMatchFeed if the channel is offline.
+func youtubeLiveVideoID(ctx context.Context, liveUrl string, proxyUrl string) (string, error) {
+	service, err := youtubeService(ctx, proxyUrl)
+	if err != nil {
+		return "", err
+	}
+	channelID, err := youtubeChannelID(ctx, service, liveUrl)
+	if err != nil {
+		return "", err
+	}
+	if entry, ok := ytLiveVideos.Load(channelID); ok && time.Now().Before(entry.expires) {
+		if entry.videoID == "" {
+			return "", NoMatchFeed
+		}
+		return entry.videoID, nil
+	}
+	var resp *youtube.SearchListResponse
+	err = youtubeCall(youtubeSearchCost, func() (err error) {
+		resp, err = service.Search.List([]string{"id"}).ChannelId(channelID).EventType("live").Type("video").Context(ctx).Do()
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Items) == 0 || resp.Items[0].Id == nil {
+		ytLiveVideos.Store(channelID, youtubeLiveEntry{expires: time.Now().Add(youtubeOfflineCacheTTL)})
+		return "", NoMatchFeed
+	}
+	videoID := resp.Items[0].Id.VideoId
+	ytLiveVideos.Store(channelID, youtubeLiveEntry{videoID: videoID, expires: time.Now().Add(youtubeLiveCacheTTL)})
+	return videoID, nil
+}
+
+// drop a cached live video that turned out to be over
+func forgetYoutubeLiveVideo(videoID string) {
+	ytLiveVideos.Range(func(channelID string, entry youtubeLiveEntry) bool {
+		if entry.videoID == videoID {
+			ytLiveVideos.Delete(channelID)
+		}
+		return true
+	})
+}
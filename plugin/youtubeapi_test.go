@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+)
+
+// a stand-in data api, the search quota runs out after searchesLeft searches
+func youtubeAPIServer(t *testing.T, searchesLeft int) *atomic.Int32 {
+	var searches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/youtube/v3/channels", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" || r.URL.Query().Get("forHandle") != "@somebody" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"items":[{"id":"UC123"}]}`)
+	})
+	mux.HandleFunc("/youtube/v3/search", func(w http.ResponseWriter, r *http.Request) {
+		if int(searches.Add(1)) > searchesLeft {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"message":"quota","errors":[{"reason":"quotaExceeded"}]}}`)
+			return
+		}
+		if r.URL.Query().Get("channelId") != "UC123" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"items":[{"id":{"kind":"youtube#video","videoId":"live1"}}]}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Setenv("LIVETV_YOUTUBE_API", srv.URL+"/")
+	return &searches
+}
+
+// a fresh quota, api key and caches for a test
+func resetYoutubeAPI(t *testing.T, limit string) {
+	for key, value := range map[string]string{"apiKey": "test-key", "youtube_quota": limit} {
+		old, had := global.ConfigCache.Load(key)
+		global.ConfigCache.Store(key, value)
+		t.Cleanup(func() {
+			if had {
+				global.ConfigCache.Store(key, old)
+			} else {
+				global.ConfigCache.Delete(key)
+			}
+		})
+	}
+	ytQuota = youtubeQuota{}
+	ytChannelIDs.Clear()
+	ytLiveVideos.Clear()
+	ytChannelStreams.Clear()
+}
+
+func TestYoutubeQuotaAccounting(t *testing.T) {
+	resetYoutubeAPI(t, "250")
+	searches := youtubeAPIServer(t, 10)
+	ctx := context.Background()
+
+	videoID, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", "")
+	if err != nil || videoID != "live1" {
+		t.Fatalf("got %q, %v", videoID, err)
+	}
+	if used, limit := YoutubeQuotaUsed(); used != youtubeListCost+youtubeSearchCost || limit != 250 {
+		t.Fatalf("used %d of %d, want %d of 250", used, limit, youtubeListCost+youtubeSearchCost)
+	}
+
+	// the channel id and the live video are cached
+	if _, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", ""); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := YoutubeQuotaUsed(); used != youtubeListCost+youtubeSearchCost {
+		t.Fatalf("a cached lookup used quota, %d units used", used)
+	}
+
+	// one more search fits in 250 units, the one after doesn't and never reaches the api
+	ytLiveVideos.Clear()
+	if _, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", ""); err != nil {
+		t.Fatal(err)
+	}
+	ytLiveVideos.Clear()
+	if _, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", ""); !errors.Is(err, errYoutubeAPIUnavailable) {
+		t.Fatalf("got %v, want errYoutubeAPIUnavailable", err)
+	}
+	if n := searches.Load(); n != 2 {
+		t.Fatalf("%d searches reached the api, want 2", n)
+	}
+}
+
+func TestYoutubeQuotaExceeded(t *testing.T) {
+	resetYoutubeAPI(t, "10000")
+	searches := youtubeAPIServer(t, 0)
+	ctx := context.Background()
+
+	// google says the quota is gone before our count does
+	if _, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", ""); !errors.Is(err, errYoutubeAPIUnavailable) {
+		t.Fatalf("got %v, want errYoutubeAPIUnavailable", err)
+	}
+	if _, err := youtubeLiveVideoID(ctx, "https://www.youtube.com/@somebody/live", ""); !errors.Is(err, errYoutubeAPIUnavailable) {
+		t.Fatalf("got %v, want errYoutubeAPIUnavailable", err)
+	}
+	if n := searches.Load(); n != 1 {
+		t.Fatalf("%d searches reached the api after it ran out of quota, want 1", n)
+	}
+
+	// the quota comes back the next day
+	ytQuota.day = "2000-01-01"
+	if !ytQuota.take(youtubeSearchCost) {
+		t.Fatal("the quota wasn't reset on a new day")
+	}
+}
+
+func TestYoutubeNoAPIKey(t *testing.T) {
+	resetYoutubeAPI(t, "10000")
+	global.ConfigCache.Store("apiKey", "")
+	_, err := youtubeLiveVideoID(context.Background(), "https://www.youtube.com/@somebody/live", "")
+	if !errors.Is(err, errYoutubeAPIUnavailable) {
+		t.Fatalf("got %v, want errYoutubeAPIUnavailable", err)
+	}
+	for _, url := range []string{"https://www.youtube.com/c/custom", "https://www.youtube.com/watch?v=abc"} {
+		if _, err := youtubeChannelID(context.Background(), nil, url); !errors.Is(err, errYoutubeAPIUnavailable) {
+			t.Errorf("%s: got %v, want errYoutubeAPIUnavailable", url, err)
+		}
+	}
+}
+
+// the streams parser refreshes often, its api fallback only searches once per channel and hour
+func TestYoutubeStreamsSearchLimit(t *testing.T) {
+	resetYoutubeAPI(t, "10000")
+	searches := youtubeAPIServer(t, 10)
+	p := &YoutubeStreamsParser{}
+	for i := 0; i < 3; i++ {
+		streams, err := p.streamsFromAPI(context.Background(), "https://www.youtube.com/@somebody", "")
+		if err != nil || len(streams) != 1 || streams[0].VideoID != "live1" {
+			t.Fatalf("got %v, %v", streams, err)
+		}
+	}
+	if n := searches.Load(); n != 1 {
+		t.Fatalf("%d searches reached the api, want 1", n)
+	}
+
+	entry, _ := ytChannelStreams.Load("UC123")
+	entry.expires = time.Now().Add(-time.Second)
+	ytChannelStreams.Store("UC123", entry)
+	if _, err := p.streamsFromAPI(context.Background(), "https://www.youtube.com/@somebody", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := searches.Load(); n != 2 {
+		t.Fatalf("%d searches reached the api after the cached result expired, want 2", n)
+	}
+}
@@ -0,0 +1,261 @@
+// youtube streams
+// a youtube channel running several live streams at once, each stream becomes a sub channel
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+	"google.golang.org/api/youtube/v3"
+)
+
+type YoutubeStream struct {
+	VideoID   string `json:"id"`
+	Title     string `json:"title"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+type YoutubeStreamsParser struct{}
+
+var ytInitialDataRegex = regexp.MustCompile(`(?s)var ytInitialData\s*=\s*(\{.+?\});\s*</script>`)
+
+const (
+	// search.list returns at most 50 results per page, no channel runs more than that at once
+	maxYoutubeStreams = 50
+	// search.list costs 100 units of the 10000 daily quota, the api fallback asks it at most once an hour per channel
+	youtubeStreamsCacheTTL = time.Hour
+)
+
+type youtubeStreamsEntry struct {
+	streams []YoutubeStream
+	expires time.Time
+}
+
+var ytChannelStreams syncx.Map[string, youtubeStreamsEntry] // channel id => live streams found by the api
+
+// the channel page costs nothing, so streams starting and ending show up soon; only the api fallback is rate limited
+func (p *YoutubeStreamsParser) Schedule() string {
+	return "@every 15m"
+}
+
+func (p *YoutubeStreamsParser) streamsFromAPI(ctx context.Context, liveUrl string, proxyUrl string) ([]YoutubeStream, error) {
+	service, err := youtubeService(ctx, proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	channelID, err := youtubeChannelID(ctx, service, liveUrl)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := ytChannelStreams.Load(channelID); ok && time.Now().Before(entry.expires) {
+		return entry.streams, nil
+	}
+	var resp *youtube.SearchListResponse
+	err = youtubeCall(youtubeSearchCost, func() (err error) {
+		resp, err = service.Search.List([]string{"snippet"}).ChannelId(channelID).EventType("live").Type("video").MaxResults(maxYoutubeStreams).Context(ctx).Do()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	streams := []YoutubeStream{}
+	for _, item := range resp.Items {
+		if item.Id == nil || item.Id.VideoId == "" {
+			continue
+		}
+		stream := YoutubeStream{VideoID: item.Id.VideoId}
+		if item.Snippet != nil {
+			stream.Title = item.Snippet.Title
+			if thumbs := item.Snippet.Thumbnails; thumbs != nil {
+				for _, t := range []*youtube.Thumbnail{thumbs.High, thumbs.Medium, thumbs.Default} {
+					if t != nil && t.Url != "" {
+						stream.Thumbnail = t.Url
+						break
+					}
+				}
+			}
+		}
+		streams = append(streams, stream)
+	}
+	ytChannelStreams.Store(channelID, youtubeStreamsEntry{streams: streams, expires: time.Now().Add(youtubeStreamsCacheTTL)})
+	return streams, nil
+}
+
+// collect every videoRenderer in ytInitialData
+func findVideoRenderers(node any, found *[]map[string]any) {
+	switch v := node.(type) {
+	case map[string]any:
+		if r, ok := v["videoRenderer"].(map[string]any); ok {
+			*found = append(*found, r)
+			return
+		}
+		for _, child := range v {
+			findVideoRenderers(child, found)
+		}
+	case []any:
+		for _, child := range v {
+			findVideoRenderers(child, found)
+		}
+	}
+}
+
+func rendererText(node any) string {
+	m, _ := node.(map[string]any)
+	if s, ok := m["simpleText"].(string); ok {
+		return s
+	}
+	var sb strings.Builder
+	runs, _ := m["runs"].([]any)
+	for _, run := range runs {
+		if r, ok := run.(map[string]any); ok {
+			s, _ := r["text"].(string)
+			sb.WriteString(s)
+		}
+	}
+	return sb.String()
+}
+
+// parse the /streams tab of a channel, upcoming and finished streams are listed there as well
+func (p *YoutubeStreamsParser) streamsFromPage(ctx context.Context, liveUrl string, proxyUrl string) ([]YoutubeStream, error) {
+	m := ytChannelUrlRegex.FindStringSubmatch(liveUrl)
+	if m == nil {
+		return nil, errors.New("not a youtube channel url")
+	}
+	client := http.Client{
+		Transport: global.TransportWithProxy(proxyUrl),
+		Jar:       cookieJar(ctx),
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.youtube.com/"+m[1]+m[2]+"/streams", nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer global.CloseBody(resp)
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamsPage(content)
+}
+
+// list the live videos in the ytInitialData of a channel page
+func parseStreamsPage(content []byte) ([]YoutubeStream, error) {
+	match := ytInitialDataRegex.FindSubmatch(content)
+	if match == nil {
+		return nil, errors.New("ytInitialData not found")
+	}
+	var data any
+	if err := json.Unmarshal(match[1], &data); err != nil {
+		return nil, err
+	}
+	var renderers []map[string]any
+	findVideoRenderers(data, &renderers)
+	streams := []YoutubeStream{}
+	seen := make(map[string]bool)
+	for _, r := range renderers {
+		videoID, _ := r["videoId"].(string)
+		if videoID == "" || seen[videoID] {
+			continue
+		}
+		// live videos carry a LIVE overlay or a live now badge
+		js, _ := json.Marshal(r["thumbnailOverlays"])
+		badges, _ := json.Marshal(r["badges"])
+		if !strings.Contains(string(js), `"LIVE"`) && !strings.Contains(string(badges), "LIVE_NOW") {
+			continue
+		}
+		seen[videoID] = true
+		stream := YoutubeStream{
+			VideoID: videoID,
+			Title:   rendererText(r["title"]),
+		}
+		if thumb, ok := r["thumbnail"].(map[string]any); ok {
+			if thumbs, ok := thumb["thumbnails"].([]any); ok && len(thumbs) > 0 {
+				if t, ok := thumbs[len(thumbs)-1].(map[string]any); ok {
+					stream.Thumbnail, _ = t["url"].(string)
+				}
+			}
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+func (p *YoutubeStreamsParser) Parse(liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	return parseWithTimeout(p, liveUrl, proxyUrl, previousExtraInfo)
+}
+
+func (p *YoutubeStreamsParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, previousExtraInfo string) (*model.LiveInfo, error) {
+	streams, err := p.streamsFromPage(ctx, liveUrl, proxyUrl)
+	if err != nil {
+		// the page changed or youtube wants a consent or a captcha, the api still works if there's a key
+		var apiErr error
+		streams, apiErr = p.streamsFromAPI(ctx, liveUrl, proxyUrl)
+		if apiErr != nil {
+			if errors.Is(apiErr, errYoutubeAPIUnavailable) {
+				return nil, err
+			}
+			return nil, apiErr
+		}
+	}
+	// an empty list is still a result, so that the sub channels of ended streams go away
+	js, _ := json.Marshal(streams)
+	li := &model.LiveInfo{}
+	li.ExtraInfo = string(js)
+	if len(streams) > 0 {
+		li.Logo = streams[0].Thumbnail
+	}
+	return li, nil
+}
+
+// sub channel ids are derived from the video so that a stream keeps its url while others start and stop
+func youtubeStreamID(videoID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(videoID))
+	return int(h.Sum32() % 1000000)
+}
+
+// channel provider
+func (p *YoutubeStreamsParser) Channels(parentChannel *model.Channel, liveInfo *model.LiveInfo) (channels []*model.Channel) {
+	var streams []YoutubeStream
+	json.Unmarshal([]byte(liveInfo.ExtraInfo), &streams)
+	for _, it := range streams {
+		id := youtubeStreamID(it.VideoID)
+		if it.Title == "" {
+			it.Title = fmt.Sprintf("%s %d", parentChannel.Name, id)
+		}
+		channel := &model.Channel{
+			ID:        id,
+			ChannelID: fmt.Sprintf("%d-%d", parentChannel.ID, id),
+			Category:  parentChannel.Category,
+			Name:      it.Title,
+			Logo:      it.Thumbnail,
+			Parser:    "youtube",
+			URL:       "https://www.youtube.com/watch?v=" + it.VideoID,
+			ProxyUrl:  parentChannel.ProxyUrl,
+			Proxy:     parentChannel.Proxy,
+			TsProxy:   parentChannel.TsProxy,
+		}
+		inheritChannelSettings(channel, parentChannel)
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func init() {
+	registerPlugin("youtube-streams", &YoutubeStreamsParser{}, 9)
+}
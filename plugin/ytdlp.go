@@ -2,11 +2,16 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
+	"net/http"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/snowie2000/livetv/model"
 
@@ -15,7 +20,48 @@ import (
 
 type YtDlpParser struct{}
 
+// the parts of yt-dlp's json output we use
+type ytdlpFormat struct {
+	FormatID    string            `json:"format_id"`
+	URL         string            `json:"url"`
+	Height      int               `json:"height"`
+	Tbr         float64           `json:"tbr"`
+	VCodec      string            `json:"vcodec"`
+	ACodec      string            `json:"acodec"`
+	HTTPHeaders map[string]string `json:"http_headers"`
+}
+
+type ytdlpInfo struct {
+	ytdlpFormat               // the format yt-dlp selected with -f, if it's a single one
+	Type        string        `json:"_type"`
+	Title       string        `json:"title"`
+	Thumbnail   string        `json:"thumbnail"`
+	Formats     []ytdlpFormat `json:"formats"`
+	Entries     []ytdlpInfo   `json:"entries"`
+}
+
+// stored in LiveInfo.ExtraInfo
+type ytdlpExtraInfo struct {
+	Format  string            `json:"format"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (p *YtDlpParser) Schedule() string {
+	return "@every 1h"
+}
+
+// yt-dlp needs to spin up python and talk to the site, it's much slower than a plain http request
+func (p *YtDlpParser) Timeout() time.Duration {
+	return 30 * time.Second
+}
+
 func (p *YtDlpParser) Parse(liveUrl string, proxyUrl string, lastInfo string) (*model.LiveInfo, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), p.Timeout())
+	defer cancelFunc()
+	return p.ParseContext(ctx, liveUrl, proxyUrl, lastInfo)
+}
+
+func (p *YtDlpParser) ParseContext(ctx context.Context, liveUrl string, proxyUrl string, lastInfo string) (*model.LiveInfo, error) {
 	YtdlCmd, err := global.GetConfig("ytdl_cmd")
 	if err != nil {
 		log.Println(err)
@@ -32,13 +78,33 @@ func (p *YtDlpParser) Parse(liveUrl string, proxyUrl string, lastInfo string) (*
 			ytdlArgs[i] = liveUrl
 		}
 	}
+	// pass the channel headers so that yt-dlp talks to the site the same way we do
+	for k, v := range ChannelHeaders(ChannelFromContext(ctx)) {
+		ytdlArgs = append([]string{"--add-header", k + ":" + v[0]}, ytdlArgs...)
+	}
+	if ch := ChannelFromContext(ctx); ch != nil && CookieJarName(ch) != "" {
+		jar := global.GetCookieJar(CookieJarName(ch))
+		exported := jar.Export()
+		if f, err := os.CreateTemp("", "livetv-cookies-*.txt"); err == nil {
+			f.WriteString(exported)
+			f.Close()
+			defer os.Remove(f.Name())
+			// yt-dlp saves the cookies it received back to the file
+			defer func() {
+				if content, err := os.ReadFile(f.Name()); err == nil && string(content) != exported {
+					jar.Import(string(content), true)
+				}
+			}()
+			ytdlArgs = append([]string{"--cookies", f.Name()}, ytdlArgs...)
+		}
+	}
 	_, err = exec.LookPath(YtdlCmd)
 	if err != nil {
 		log.Println(err)
 		return nil, err
+	} else if ytdlpJSONMode(ytdlArgs) {
+		return p.parseJSON(ctx, YtdlCmd, ytdlArgs)
 	} else {
-		ctx, cancelFunc := context.WithTimeout(context.Background(), global.HttpClientTimeout)
-		defer cancelFunc()
 		cmd := exec.CommandContext(ctx, YtdlCmd, ytdlArgs...)
 		out, err := cmd.CombinedOutput()
 		output := strings.TrimSpace(string(out))
@@ -64,6 +130,88 @@ func (p *YtDlpParser) Parse(liveUrl string, proxyUrl string, lastInfo string) (*
 	}
 }
 
+// -J and -j make yt-dlp print json instead of bare urls
+func ytdlpJSONMode(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-J", "-j", "--dump-json", "--dump-single-json":
+			return true
+		}
+	}
+	return false
+}
+
+// use the format yt-dlp selected, unless the channel prefers another quality
+func (info *ytdlpInfo) selectFormat(q Quality) ytdlpFormat {
+	if q == (Quality{}) && info.URL != "" {
+		return info.ytdlpFormat
+	}
+	var muxed []ytdlpFormat
+	for _, f := range info.Formats {
+		// skip audio only and video only formats, we can only serve one url
+		if f.URL != "" && f.VCodec != "none" && f.ACodec != "none" {
+			muxed = append(muxed, f)
+		}
+	}
+	if len(muxed) == 0 {
+		return info.ytdlpFormat
+	}
+	i := q.Pick(len(muxed), func(i int) int { return muxed[i].Height }, func(i int) float64 { return muxed[i].Tbr })
+	return muxed[i]
+}
+
+func (p *YtDlpParser) parseJSON(ctx context.Context, cmdName string, args []string) (*model.LiveInfo, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cmdName, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	var info ytdlpInfo
+	// -j prints one object per line, the first one is enough
+	if err := json.NewDecoder(&stdout).Decode(&info); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Join(errors.New(msg+" , "), runErr, err)
+		}
+		return nil, errors.Join(runErr, err)
+	}
+	if info.Type == "playlist" && len(info.Entries) > 0 {
+		info = info.Entries[0]
+	}
+	format := info.selectFormat(channelQuality(ctx))
+	if format.URL == "" {
+		return nil, errors.New("yt-dlp found no playable format")
+	}
+	headers := format.HTTPHeaders
+	if len(headers) == 0 {
+		headers = info.HTTPHeaders
+	}
+	extra, _ := json.Marshal(ytdlpExtraInfo{Format: format.FormatID, Headers: headers})
+	return &model.LiveInfo{
+		LiveUrl:   format.URL,
+		Logo:      info.Thumbnail,
+		Title:     info.Title,
+		ExtraInfo: string(extra),
+	}, nil
+}
+
+func unpackYtdlpExtra(extraInfo string) ytdlpExtraInfo {
+	var ei ytdlpExtraInfo
+	json.Unmarshal([]byte(extraInfo), &ei)
+	return ei
+}
+
+// send the headers yt-dlp used, some sites refuse the stream without them
+func (p *YtDlpParser) Transform(req *http.Request, info *model.LiveInfo) error {
+	for k, v := range unpackYtdlpExtra(info.ExtraInfo).Headers {
+		req.Header.Set(k, v)
+	}
+	return nil
+}
+
+func (p *YtDlpParser) TransformTs(rawLink string, tsLink string, info *model.LiveInfo) string {
+	return tsLinkWithHeaders(tsLink, unpackYtdlpExtra(info.ExtraInfo).Headers)
+}
+
 func init() {
 	registerPlugin("yt-dlp", &YtDlpParser{}, 7)
 }
@@ -0,0 +1,39 @@
+package plugin
+
+import "testing"
+
+func TestYtdlpSelectFormat(t *testing.T) {
+	info := &ytdlpInfo{
+		ytdlpFormat: ytdlpFormat{FormatID: "selected", URL: "https://example.com/selected.m3u8", Height: 720},
+		Formats: []ytdlpFormat{
+			{FormatID: "audio", URL: "https://example.com/audio", VCodec: "none", ACodec: "mp4a"},
+			{FormatID: "video-only", URL: "https://example.com/video", Height: 2160, Tbr: 20000, VCodec: "avc1", ACodec: "none"},
+			{FormatID: "360", URL: "https://example.com/360.m3u8", Height: 360, Tbr: 800, VCodec: "avc1", ACodec: "mp4a"},
+			{FormatID: "1080", URL: "https://example.com/1080.m3u8", Height: 1080, Tbr: 5000, VCodec: "avc1", ACodec: "mp4a"},
+			{FormatID: "720", URL: "https://example.com/720.m3u8", Height: 720, Tbr: 2500, VCodec: "avc1", ACodec: "mp4a"},
+			{FormatID: "no-url", Height: 1440, Tbr: 9000, VCodec: "avc1", ACodec: "mp4a"},
+		},
+	}
+	tests := []struct {
+		quality string
+		want    string
+	}{
+		{"best", "selected"}, // yt-dlp's own choice is kept
+		{"worst", "360"},
+		{"720p", "720"},
+		{"480p", "360"},
+		{"240p", "360"}, // nothing fits, the smallest one
+	}
+	for _, tt := range tests {
+		q, _ := ParseQuality(tt.quality)
+		if got := info.selectFormat(q); got.FormatID != tt.want {
+			t.Errorf("%s selected %s, want %s", tt.quality, got.FormatID, tt.want)
+		}
+	}
+
+	// without a selected format the best muxed one is used
+	info.ytdlpFormat = ytdlpFormat{}
+	if got := info.selectFormat(Quality{}); got.FormatID != "1080" {
+		t.Errorf("best selected %s, want 1080", got.FormatID)
+	}
+}
@@ -12,6 +12,8 @@ func Register(r *gin.Engine) {
 	r.GET("/live.m3u8", handler.LiveHandler)
 	r.HEAD("/live.m3u8", handler.LivePreHandler)
 	r.GET("/live.ts", handler.TsProxyHandler)
+	r.GET("/stream.ts", handler.StreamHandler)
+	r.GET("/slate.ts", handler.SlateHandler)
 	r.GET("/playlist.m3u8", handler.M3U8ProxyHandler)
 	r.GET("/cache.txt", handler.CacheHandler)
 
@@ -25,6 +27,26 @@ func Register(r *gin.Engine) {
 	r.POST("/api/updconfig", handler.UpdateConfigHandler)
 	r.GET("/api/auth", handler.AuthProbeHandler)
 	r.GET("/api/category", handler.CategoryHandler)
+	r.GET("/api/webhooks", handler.WebhookListHandler)
+	r.POST("/api/newwebhook", handler.NewWebhookHandler)
+	r.POST("/api/updatewebhook", handler.UpdateWebhookHandler)
+	r.GET("/api/delwebhook", handler.DeleteWebhookHandler)
+	r.GET("/api/testwebhook", handler.TestWebhookHandler)
+	r.GET("/api/scripts", handler.ScriptListHandler)
+	r.POST("/api/newscript", handler.NewScriptHandler)
+	r.POST("/api/updatescript", handler.UpdateScriptHandler)
+	r.GET("/api/delscript", handler.DeleteScriptHandler)
+	r.GET("/api/execparsers", handler.ExecParserListHandler)
+	r.POST("/api/newexecparser", handler.NewExecParserHandler)
+	r.POST("/api/updateexecparser", handler.UpdateExecParserHandler)
+	r.GET("/api/delexecparser", handler.DeleteExecParserHandler)
+	r.GET("/api/cookiejars", handler.CookieJarListHandler)
+	r.POST("/api/importcookies", handler.ImportCookiesHandler)
+	r.GET("/api/exportcookies", handler.ExportCookiesHandler)
+	r.GET("/api/delcookiejar", handler.DeleteCookieJarHandler)
+	r.GET("/api/slates", handler.SlateListHandler)
+	r.POST("/api/uploadslate", handler.UploadSlateHandler)
+	r.GET("/api/delslate", handler.DeleteSlateHandler)
 	r.GET("/log", handler.LogHandler)
 	// r.GET("/login", handler.LoginViewHandler)
 	r.POST("/api/login", handler.LoginActionHandler)
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/syncx"
+)
+
+// AdFilter holds the rules that remove ad breaks from the media playlists of a channel
+type AdFilter struct {
+	URI           []string `json:"uri"`           // regular expressions, segments with a matching uri are dropped
+	Cue           bool     `json:"cue"`           // drop the segments between cue-out and cue-in markers
+	Discontinuity float64  `json:"discontinuity"` // drop the runs between two discontinuities that last at most this many seconds
+	MinDuration   float64  `json:"minduration"`   // drop segments shorter than this many seconds
+	MaxDuration   float64  `json:"maxduration"`   // drop segments longer than this many seconds
+	uriRegex      []*regexp.Regexp
+}
+
+const (
+	segmentKeep = iota
+	segmentDrop
+	segmentHold // at the live edge and not decided yet, left out until it is
+)
+
+const (
+	cueNone = iota
+	cueOut
+	cueCont
+	cueIn
+	cueInfo // scte-35 data that doesn't change the state of a break
+)
+
+// a segment of the upstream playlist
+type rawSegment struct {
+	lines         []string // tags and the uri, without the ones rewritten by the filter
+	uri           string
+	duration      float64
+	seq           uint64
+	disc          uint64 // discontinuity sequence number
+	discontinuity bool
+	key           string
+	mapTag        string
+	cue           int
+	cueDuration   float64 // seconds left of the break that starts or continues here, 0 if unknown
+}
+
+// where an ad break stands after a segment
+type cueState struct {
+	inBreak   bool
+	remaining float64 // seconds, 0 if the break lasts until a cue-in
+}
+
+// what became of a segment, so that it's numbered the same way on every reload
+type filteredSegment struct {
+	dropped       bool
+	seq           uint64 // media sequence number in the filtered playlist
+	disc          uint64 // discontinuity sequence number in the filtered playlist
+	discontinuity bool
+	cue           cueState
+}
+
+type adFilterState struct {
+	segments map[uint64]filteredSegment // by upstream sequence number
+	last     *filteredSegment           // the last segment kept
+}
+
+// tags that describe the whole playlist instead of the segment after them
+var playlistTags = map[string]bool{
+	"#EXTM3U":                     true,
+	"#EXT-X-VERSION":              true,
+	"#EXT-X-TARGETDURATION":       true,
+	"#EXT-X-PLAYLIST-TYPE":        true,
+	"#EXT-X-INDEPENDENT-SEGMENTS": true,
+	"#EXT-X-START":                true,
+	"#EXT-X-ALLOW-CACHE":          true,
+	"#EXT-X-I-FRAMES-ONLY":        true,
+}
+
+var (
+	adFilters      syncx.Map[string, *AdFilter]
+	adFilterStates = cache.New(5*time.Minute, 10*time.Minute)
+	adFilterLock   sync.Mutex
+)
+
+// ParseAdFilter reads the ad filter rules of a channel, nil if it has none
+func ParseAdFilter(config string) (*AdFilter, error) {
+	config = strings.TrimSpace(config)
+	if config == "" {
+		return nil, nil
+	}
+	var f AdFilter
+	if err := json.Unmarshal([]byte(config), &f); err != nil {
+		return nil, err
+	}
+	for _, pattern := range f.URI {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.uriRegex = append(f.uriRegex, re)
+	}
+	return &f, nil
+}
+
+// ChannelAdFilter returns the ad filter of a channel, nil if it has none or its rules are broken
+func ChannelAdFilter(channel *model.Channel) *AdFilter {
+	if f, ok := adFilters.Load(channel.AdFilter); ok {
+		return f
+	}
+	f, err := ParseAdFilter(channel.AdFilter)
+	if err != nil {
+		log.Println("ad filter of", channel.Name, "is invalid:", err)
+	}
+	adFilters.Store(channel.AdFilter, f)
+	return f
+}
+
+// the filter state of a playlist, the query is left out since it usually carries tokens
+func adFilterKey(channelNum int, playlistUrl string) string {
+	u, _, _ := strings.Cut(playlistUrl, "?")
+	return fmt.Sprintf("%d|%s", channelNum, u)
+}
+
+func tagName(line string) string {
+	name, _, _ := strings.Cut(line, ":")
+	return name
+}
+
+// the value of an attribute of a tag, names are case insensitive since scte-35 tags are written in every style
+func tagAttr(line string, name string) string {
+	_, attrs, _ := strings.Cut(line, ":")
+	quoted := false
+	start := 0
+	for i := 0; i <= len(attrs); i++ {
+		if i < len(attrs) && attrs[i] == '"' {
+			quoted = !quoted
+		}
+		if i < len(attrs) && (quoted || attrs[i] != ',') {
+			continue
+		}
+		if k, v, ok := strings.Cut(attrs[start:i], "="); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.Trim(strings.TrimSpace(v), `"`)
+		}
+		start = i + 1
+	}
+	return ""
+}
+
+func tagFloat(line string, name string) float64 {
+	v, _ := strconv.ParseFloat(tagAttr(line, name), 64)
+	return v
+}
+
+// recognizes the ad markers of the common scte-35 flavors, and how long the break lasts from here if it's known
+func cueMarker(line string) (int, float64) {
+	switch tagName(line) {
+	case "#EXT-X-CUE-OUT":
+		// #EXT-X-CUE-OUT:30 or #EXT-X-CUE-OUT:DURATION=30
+		_, value, _ := strings.Cut(line, ":")
+		duration, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			duration = tagFloat(line, "DURATION")
+		}
+		return cueOut, duration
+	case "#EXT-X-CUE-OUT-CONT":
+		duration, elapsed := tagFloat(line, "Duration"), tagFloat(line, "ElapsedTime")
+		if duration > elapsed {
+			return cueCont, duration - elapsed
+		}
+		return cueCont, 0
+	case "#EXT-X-CUE-IN":
+		return cueIn, 0
+	case "#EXT-X-SCTE35":
+		switch {
+		case strings.EqualFold(tagAttr(line, "CUE-OUT"), "YES"):
+			return cueOut, tagFloat(line, "DURATION")
+		case strings.EqualFold(tagAttr(line, "CUE-OUT"), "CONT"):
+			return cueCont, 0
+		case strings.EqualFold(tagAttr(line, "CUE-IN"), "YES"):
+			return cueIn, 0
+		}
+		return cueInfo, 0
+	case "#EXT-X-DATERANGE":
+		switch {
+		case tagAttr(line, "SCTE35-OUT") != "":
+			duration := tagFloat(line, "DURATION")
+			if duration == 0 {
+				duration = tagFloat(line, "PLANNED-DURATION")
+			}
+			return cueOut, duration
+		case tagAttr(line, "SCTE35-IN") != "":
+			return cueIn, 0
+		}
+	case "#EXT-OATCLS-SCTE35":
+		return cueInfo, 0
+	}
+	return cueNone, 0
+}
+
+// splits a media playlist into its header and its segments, lines after the last segment are dropped except for the end tag
+func (f *AdFilter) parse(data string) (header []string, segments []*rawSegment, endList bool) {
+	var mediaSeq, discSeq uint64
+	seg := &rawSegment{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			seg.uri = line
+			seg.lines = append(seg.lines, line)
+			seg.seq = mediaSeq + uint64(len(segments))
+			if seg.discontinuity {
+				discSeq++
+			}
+			seg.disc = discSeq
+			segments = append(segments, seg)
+			seg = &rawSegment{}
+			continue
+		}
+		name := tagName(line)
+		switch {
+		case name == "#EXT-X-MEDIA-SEQUENCE":
+			mediaSeq, _ = strconv.ParseUint(strings.TrimPrefix(line, name+":"), 10, 64)
+			continue
+		case name == "#EXT-X-DISCONTINUITY-SEQUENCE":
+			discSeq, _ = strconv.ParseUint(strings.TrimPrefix(line, name+":"), 10, 64)
+			continue
+		case name == "#EXT-X-ENDLIST":
+			endList = true
+			continue
+		case playlistTags[name]:
+			header = append(header, line)
+			continue
+		case name == "#EXT-X-DISCONTINUITY":
+			seg.discontinuity = true
+			continue
+		case name == "#EXT-X-KEY":
+			seg.key = line
+			continue
+		case name == "#EXT-X-MAP":
+			seg.mapTag = line
+			continue
+		case name == "#EXTINF":
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, name+":"), ",")
+			seg.duration, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+		if kind, duration := cueMarker(line); kind != cueNone {
+			if kind != cueInfo {
+				seg.cue, seg.cueDuration = kind, duration
+			}
+			if f.Cue {
+				continue // the markers of removed breaks would only confuse players
+			}
+		}
+		seg.lines = append(seg.lines, line)
+	}
+	return
+}
+
+// what the discontinuity rule makes of every segment: short runs between two discontinuities are dropped,
+// a short run at the live edge is held back until it's either closed or long enough to be the programme
+func (f *AdFilter) discontinuityVerdicts(segments []*rawSegment, endList bool) []int {
+	verdicts := make([]int, len(segments))
+	if f.Discontinuity <= 0 {
+		return verdicts
+	}
+	start := -1 // the run in the window that starts with a discontinuity
+	var length float64
+	for i := 0; i <= len(segments); i++ {
+		if i == len(segments) || segments[i].discontinuity {
+			if start >= 0 && length <= f.Discontinuity {
+				verdict := segmentDrop
+				if i == len(segments) {
+					verdict = segmentHold
+					if endList {
+						verdict = segmentKeep
+					}
+				}
+				for j := start; j < i; j++ {
+					verdicts[j] = verdict
+				}
+			}
+			start, length = i, 0
+		}
+		if i < len(segments) {
+			length += segments[i].duration
+		}
+	}
+	return verdicts
+}
+
+func (f *AdFilter) matchURI(uri string) bool {
+	for _, re := range f.uriRegex {
+		if re.MatchString(uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply drops the ad segments of a media playlist. The segments that are left are numbered on, so that the media and
+// discontinuity sequences keep growing across reloads, and a discontinuity marks every place where segments were removed.
+// key identifies the playlist, decisions once made stay the same as long as a segment is in the window.
+// Low latency playlists are left alone, their parts and preload hints follow the last segment and can't be renumbered.
+func (f *AdFilter) Apply(key string, data string) string {
+	if f == nil || !strings.Contains(data, "#EXTINF") || isLowLatencyPlaylist(data) {
+		return data
+	}
+	header, segments, endList := f.parse(data)
+	verdicts := f.discontinuityVerdicts(segments, endList)
+
+	adFilterLock.Lock()
+	defer adFilterLock.Unlock()
+	state := &adFilterState{segments: make(map[uint64]filteredSegment)}
+	if s, ok := adFilterStates.Get(key); ok {
+		state = s.(*adFilterState)
+	}
+	var (
+		body         strings.Builder
+		cue          cueState
+		prev         *filteredSegment // the last segment kept in this window
+		first        *filteredSegment
+		removed      bool   // segments were dropped since prev
+		pendingKey   string // key and map tags of dropped segments, they apply to the next one kept
+		pendingMap   string
+		currentKey   string
+		ivPinned     bool // the last key tag written has an iv that only fits its own segment
+		held         bool
+		seen         = make(map[uint64]filteredSegment)
+		newSegmentOf = func(seg *rawSegment, discontinuity bool) filteredSegment {
+			out := filteredSegment{seq: seg.seq, disc: seg.disc, cue: cue}
+			last := prev
+			if last == nil {
+				last = state.last
+			}
+			if last != nil {
+				out.seq = last.seq + 1
+				out.disc = last.disc
+				out.discontinuity = discontinuity
+				if discontinuity {
+					out.disc++
+				}
+			}
+			return out
+		}
+	)
+	for i, seg := range segments {
+		out, decided := state.segments[seg.seq]
+		if !decided {
+			if verdicts[i] == segmentHold {
+				held = true
+				break
+			}
+			switch seg.cue {
+			case cueOut:
+				cue = cueState{inBreak: true, remaining: seg.cueDuration}
+			case cueCont:
+				cue.inBreak = true
+				if seg.cueDuration > 0 {
+					cue.remaining = seg.cueDuration
+				}
+			case cueIn:
+				cue = cueState{}
+			}
+			inBreak := cue.inBreak
+			if cue.inBreak && cue.remaining > 0 {
+				if cue.remaining -= seg.duration; cue.remaining < 0.1 {
+					cue = cueState{}
+				}
+			}
+			if (f.Cue && inBreak) || verdicts[i] == segmentDrop || f.matchURI(seg.uri) ||
+				(f.MinDuration > 0 && seg.duration < f.MinDuration) || (f.MaxDuration > 0 && seg.duration > f.MaxDuration) {
+				out = filteredSegment{dropped: true, cue: cue}
+			} else {
+				out = newSegmentOf(seg, seg.discontinuity || removed)
+			}
+		}
+		cue = out.cue
+		seen[seg.seq] = out
+		if seg.key != "" {
+			currentKey = seg.key
+		}
+		if out.dropped {
+			removed = true
+			if seg.key != "" {
+				pendingKey = seg.key
+			}
+			if seg.mapTag != "" {
+				pendingMap = seg.mapTag
+			}
+			continue
+		}
+
+		// the first segment's discontinuity is told by the discontinuity sequence
+		if out.discontinuity && first != nil {
+			body.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		keyTag := seg.key
+		if keyTag == "" {
+			keyTag = pendingKey
+		}
+		if keyTag == "" && ivPinned {
+			keyTag = currentKey
+		}
+		// renumbered segments would be decrypted with the wrong iv, make it explicit
+		ivPinned = out.seq != seg.seq && currentKey != "" && tagAttr(currentKey, "METHOD") != "NONE" && tagAttr(currentKey, "IV") == ""
+		if ivPinned {
+			keyTag = fmt.Sprintf("%s,IV=0x%032x", currentKey, seg.seq)
+		}
+		if keyTag != "" {
+			body.WriteString(keyTag + "\n")
+		}
+		if mapTag := seg.mapTag; mapTag != "" || pendingMap != "" {
+			if mapTag == "" {
+				mapTag = pendingMap
+			}
+			body.WriteString(mapTag + "\n")
+		}
+		for _, line := range seg.lines {
+			body.WriteString(line + "\n")
+		}
+		pendingKey, pendingMap, removed = "", "", false
+		kept := out
+		prev = &kept
+		if first == nil {
+			first = prev
+		}
+	}
+	// segments that left the window won't be back
+	state.segments = seen
+	if prev != nil {
+		state.last = prev
+	}
+	adFilterStates.Set(key, state, cache.DefaultExpiration)
+
+	var mediaSeq, discSeq uint64
+	switch {
+	case first != nil:
+		mediaSeq, discSeq = first.seq, first.disc
+	case state.last != nil:
+		mediaSeq, discSeq = state.last.seq+1, state.last.disc
+	case len(segments) > 0:
+		mediaSeq, discSeq = segments[0].seq, segments[0].disc
+	}
+	var sb strings.Builder
+	for _, line := range header {
+		sb.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&sb, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSeq)
+	if discSeq > 0 {
+		fmt.Fprintf(&sb, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discSeq)
+	}
+	sb.WriteString(body.String())
+	if endList && !held {
+		sb.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return sb.String()
+}
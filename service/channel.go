@@ -40,6 +40,20 @@ func loadChannel(ch *model.Channel, loadSubChannels bool) {
 			}
 		}
 	}
+	if loadSubChannels {
+		// drop sub channels the provider doesn't list anymore
+		current := make(map[string]bool)
+		for _, sub := range ch.Children {
+			current[sub.ChannelID] = true
+		}
+		prefix := strconv.Itoa(ch.ID) + "-"
+		global.ChannelCache.Range(func(key string, value model.Channel) bool {
+			if strings.HasPrefix(key, prefix) && !current[key] {
+				global.ChannelCache.Delete(key)
+			}
+			return true
+		})
+	}
 	if len(ch.Children) > 0 {
 		for _, sub := range ch.Children {
 			cache, ok := global.ChannelCache.Load(sub.ChannelID)
@@ -66,31 +80,44 @@ func GetAllChannel() (channels []*model.Channel, err error) {
 }
 
 func SaveChannel(channel *model.Channel) error {
-	DeleteChannel(channel.ID)
+	// the row is updated in place, so that the channel keeps its cookies
+	teardownChannel(channel.ID)
 	// clear children info before saving
 	children := channel.Children
 	channel.Children = []*model.Channel{}
 	err := global.DB.Save(channel).Error
 	channel.Children = children
+	if err == nil {
+		ScheduleChannel(channel)
+	}
 	return err
 }
 
 func DeleteChannel(id int) error {
+	teardownChannel(id)
+	// jars shared by name may still be used by other channels, only drop the channel's own one
+	global.DeleteCookieJar(plugin.CookieJarName(&model.Channel{ID: id}))
+	return global.DB.Delete(model.Channel{}, "id = ?", id).Error
+}
+
+// stop the parses and refreshes of a channel and drop its caches, before it's changed or deleted
+func teardownChannel(id int) {
 	var keys []string
 	CancelChannelParser(id) // cancel the parser
+	UnscheduleChannel(id)
 	// iterate and delete the channel and all its subchannels
 	global.ChannelCache.Range(func(key string, value model.Channel) bool {
 		sid := strconv.Itoa(id)
 		if key == sid || strings.HasPrefix(key, sid+"-") {
 			keys = append(keys, key)
 			statusCache.Delete(value.URL)
+			cancelExpiryReparse(value.URL)
 		}
 		return true
 	})
 	for _, key := range keys {
 		global.ChannelCache.Delete(key)
 	}
-	return global.DB.Delete(model.Channel{}, "id = ?", id).Error
 }
 
 func InvalidateChannelCache(channels ...string) {
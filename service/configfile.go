@@ -0,0 +1,382 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+// ConfigFile is the declarative configuration of a server, kept in a yaml or toml file
+type ConfigFile struct {
+	Settings map[string]any    `yaml:"settings" toml:"settings"`
+	Channels []DeclaredChannel `yaml:"channels" toml:"channels"`
+}
+
+// DeclaredChannel is a channel of the config file, the fields are named like the ones of the web api
+type DeclaredChannel struct {
+	Name         string `yaml:"name" toml:"name"`
+	URL          string `yaml:"url" toml:"url"`
+	Parser       string `yaml:"parser" toml:"parser"`
+	Category     string `yaml:"category" toml:"category"`
+	Proxy        bool   `yaml:"proxy" toml:"proxy"`
+	TsProxy      string `yaml:"tsproxy" toml:"tsproxy"`
+	Decrypt      bool   `yaml:"decrypt" toml:"decrypt"`
+	ProxyUrl     string `yaml:"proxyurl" toml:"proxyurl"`
+	Schedule     string `yaml:"schedule" toml:"schedule"`
+	Timeout      int    `yaml:"timeout" toml:"timeout"`
+	ParserConfig string `yaml:"parserconfig" toml:"parserconfig"`
+	UserAgent    string `yaml:"useragent" toml:"useragent"`
+	Referer      string `yaml:"referer" toml:"referer"`
+	Origin       string `yaml:"origin" toml:"origin"`
+	Cookie       string `yaml:"cookie" toml:"cookie"`
+	Headers      string `yaml:"headers" toml:"headers"`
+	CookieJar    string `yaml:"cookiejar" toml:"cookiejar"`
+	Quality      string `yaml:"quality" toml:"quality"`
+	AudioLang    string `yaml:"audiolang" toml:"audiolang"`
+	SubsLang     string `yaml:"subslang" toml:"subslang"`
+	Slate        string `yaml:"slate" toml:"slate"`
+	AdFilter     string `yaml:"adfilter" toml:"adfilter"`
+}
+
+// the settings a config file may declare and how their values are checked, the password is left to the -pwd flag
+var declarableSettings = map[string]func(string) error{
+	"base_url":         nil,
+	"secret":           nil,
+	"apiKey":           nil,
+	"ytdl_cmd":         nil,
+	"ytdl_args":        nil,
+	"refresh_schedule": func(v string) error { _, err := ParseSchedule(v); return err },
+	"parse_workers":    checkInt,
+	"webhook_debounce": checkNonNegative,
+	"youtube_quota":    checkInt,
+	"offline_slate":    CheckSlate,
+}
+
+// settings whose values are not printed
+var secretSettings = map[string]bool{"secret": true, "apiKey": true}
+
+var configFileLock sync.Mutex
+
+func checkInt(v string) error {
+	_, err := strconv.Atoi(v)
+	return err
+}
+
+func checkNonNegative(v string) error {
+	n, err := strconv.Atoi(v)
+	if err == nil && n < 0 {
+		return errors.New("must not be negative")
+	}
+	return err
+}
+
+// LoadConfigFile reads a config file, its format is told by the extension
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cf := &ConfigFile{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cf)
+	case ".toml":
+		err = toml.Unmarshal(data, cf)
+	default:
+		return nil, errors.New("config file must be .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cf, nil
+}
+
+func settingValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
+
+func (d *DeclaredChannel) channel() *model.Channel {
+	return &model.Channel{
+		Name:         strings.TrimSpace(d.Name),
+		URL:          strings.TrimSpace(d.URL),
+		Parser:       strings.TrimSpace(d.Parser),
+		Category:     d.Category,
+		Proxy:        d.Proxy,
+		TsProxy:      d.TsProxy,
+		Decrypt:      d.Decrypt,
+		ProxyUrl:     d.ProxyUrl,
+		Schedule:     strings.TrimSpace(d.Schedule),
+		Timeout:      d.Timeout,
+		ParserConfig: d.ParserConfig,
+		UserAgent:    strings.TrimSpace(d.UserAgent),
+		Referer:      strings.TrimSpace(d.Referer),
+		Origin:       strings.TrimSpace(d.Origin),
+		Cookie:       strings.TrimSpace(d.Cookie),
+		Headers:      d.Headers,
+		CookieJar:    strings.TrimSpace(d.CookieJar),
+		Quality:      strings.TrimSpace(d.Quality),
+		AudioLang:    strings.TrimSpace(d.AudioLang),
+		SubsLang:     strings.TrimSpace(d.SubsLang),
+		Slate:        strings.TrimSpace(d.Slate),
+		AdFilter:     strings.TrimSpace(d.AdFilter),
+		Managed:      true,
+	}
+}
+
+// the same checks the web api makes when a channel is saved
+func validateDeclaredChannel(ch *model.Channel, dryRun bool) error {
+	if ch.Name == "" || ch.URL == "" {
+		return errors.New("name and url are required")
+	}
+	p, err := plugin.GetPlugin(ch.Parser)
+	switch {
+	case err == nil:
+		if validator, ok := p.(plugin.ConfigValidator); ok {
+			if err := validator.ValidateConfig(ch.ParserConfig); err != nil {
+				return fmt.Errorf("invalid parser config: %w", err)
+			}
+		}
+	case dryRun && isEnabledScript(ch.Parser):
+		// a dry run doesn't run scripts, so they aren't registered
+	default:
+		return fmt.Errorf("unknown parser %q", ch.Parser)
+	}
+	if ch.Schedule != "" {
+		if _, err := ParseSchedule(ch.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+	if _, err := plugin.ParseQuality(ch.Quality); err != nil {
+		return fmt.Errorf("invalid quality: %w", err)
+	}
+	if err := CheckSlate(ch.Slate); err != nil {
+		return fmt.Errorf("invalid slate: %w", err)
+	}
+	if _, err := ParseAdFilter(ch.AdFilter); err != nil {
+		return fmt.Errorf("invalid ad filter: %w", err)
+	}
+	return nil
+}
+
+// the fields a config file sets, by their names in the file
+func declaredFields(ch *model.Channel) [][2]string {
+	return [][2]string{
+		{"name", ch.Name},
+		{"url", ch.URL},
+		{"parser", ch.Parser},
+		{"category", ch.Category},
+		{"proxy", strconv.FormatBool(ch.Proxy)},
+		{"tsproxy", ch.TsProxy},
+		{"decrypt", strconv.FormatBool(ch.Decrypt)},
+		{"proxyurl", ch.ProxyUrl},
+		{"schedule", ch.Schedule},
+		{"timeout", strconv.Itoa(ch.Timeout)},
+		{"parserconfig", ch.ParserConfig},
+		{"useragent", ch.UserAgent},
+		{"referer", ch.Referer},
+		{"origin", ch.Origin},
+		{"cookie", ch.Cookie},
+		{"headers", ch.Headers},
+		{"cookiejar", ch.CookieJar},
+		{"quality", ch.Quality},
+		{"audiolang", ch.AudioLang},
+		{"subslang", ch.SubsLang},
+		{"slate", ch.Slate},
+		{"adfilter", ch.AdFilter},
+	}
+}
+
+// the differences between a channel in the database and its declaration, like `url "a" -> "b"`
+func channelDiff(current *model.Channel, declared *model.Channel) []string {
+	var diff []string
+	have := declaredFields(current)
+	for i, field := range declaredFields(declared) {
+		if have[i][1] != field[1] {
+			diff = append(diff, fmt.Sprintf("%s %q -> %q", field[0], have[i][1], field[1]))
+		}
+	}
+	return diff
+}
+
+type configChange struct {
+	summary string
+	apply   func() error
+}
+
+// plan the changes that bring the database in line with a config file, nothing is changed yet
+func planConfigFile(cf *ConfigFile, dryRun bool) ([]configChange, error) {
+	var changes []configChange
+	keys := make([]string, 0, len(cf.Settings))
+	for key := range cf.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		check, ok := declarableSettings[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		v := settingValue(cf.Settings[key])
+		if key == "base_url" {
+			v = strings.TrimSuffix(v, "/")
+		}
+		if check != nil && v != "" {
+			if err := check(v); err != nil {
+				return nil, fmt.Errorf("setting %s: %w", key, err)
+			}
+		}
+		if current, _ := global.GetConfig(key); current != v {
+			shown, was := strconv.Quote(v), strconv.Quote(current)
+			if secretSettings[key] {
+				shown, was = `"***"`, `"***"`
+			}
+			changes = append(changes, configChange{
+				summary: fmt.Sprintf("~ setting %s: %s -> %s", key, was, shown),
+				apply: func() error {
+					if err := global.SetConfig(key, v); err != nil {
+						return err
+					}
+					switch key {
+					case "secret":
+						global.ClearSecretToken()
+					case "refresh_schedule":
+						RescheduleDefaultChannels()
+					}
+					return nil
+				},
+			})
+		}
+	}
+
+	var channels []*model.Channel
+	if err := global.DB.Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	wants := make([]*model.Channel, len(cf.Channels))
+	declared := make(map[string]bool)
+	for i := range cf.Channels {
+		want := cf.Channels[i].channel()
+		if err := validateDeclaredChannel(want, dryRun); err != nil {
+			return nil, fmt.Errorf("channel %q: %w", want.Name, err)
+		}
+		if declared[want.Name] {
+			return nil, fmt.Errorf("channel %q is declared twice", want.Name)
+		}
+		declared[want.Name] = true
+		wants[i] = want
+	}
+	claimed := make(map[int]bool) // ids of the channels in the database that a declaration matched
+	for _, want := range wants {
+		// a managed channel of the same name, or else an unmanaged one that the file takes over,
+		// or else a managed channel of the same url whose name is gone from the file, it has been renamed
+		var current *model.Channel
+		for _, ch := range channels {
+			if ch.Name == want.Name && (current == nil || ch.Managed && !current.Managed) {
+				current = ch
+			}
+		}
+		if current == nil {
+			for _, ch := range channels {
+				if ch.Managed && ch.URL == want.URL && !declared[ch.Name] && !claimed[ch.ID] {
+					current = ch
+					break
+				}
+			}
+		}
+		if current != nil {
+			claimed[current.ID] = true
+		}
+		switch {
+		case current == nil:
+			changes = append(changes, configChange{
+				summary: fmt.Sprintf("+ channel %q (%s %s)", want.Name, want.Parser, want.URL),
+				apply:   func() error { return saveDeclaredChannel(want) },
+			})
+		default:
+			diff := channelDiff(current, want)
+			if current.Managed && len(diff) == 0 {
+				continue
+			}
+			summary := fmt.Sprintf("~ channel %q: %s", want.Name, strings.Join(diff, ", "))
+			if !current.Managed {
+				summary = fmt.Sprintf("~ channel %q is now managed by the config file", want.Name)
+				if len(diff) > 0 {
+					summary += ": " + strings.Join(diff, ", ")
+				}
+			}
+			want.ID = current.ID
+			changes = append(changes, configChange{
+				summary: summary,
+				apply:   func() error { return saveDeclaredChannel(want) },
+			})
+		}
+	}
+	for _, ch := range channels {
+		if ch.Managed && !claimed[ch.ID] {
+			id := ch.ID
+			changes = append(changes, configChange{
+				summary: fmt.Sprintf("- channel %q", ch.Name),
+				apply:   func() error { return DeleteChannel(id) },
+			})
+		}
+	}
+	return changes, nil
+}
+
+func saveDeclaredChannel(ch *model.Channel) error {
+	if p, err := plugin.GetPlugin(ch.Parser); err == nil {
+		_, ch.HasSubChannel = p.(plugin.ChannalProvider)
+	}
+	if err := SaveChannel(ch); err != nil {
+		return err
+	}
+	go UpdateURLCacheSingle(ch, true)
+	return nil
+}
+
+// ApplyConfigFile reconciles the database with a config file and returns the changes, they're only listed on a dry run.
+// Nothing is changed if the file has an error. A change that fails stops the ones after it, those before it stay
+// and the next apply picks up the rest.
+func ApplyConfigFile(path string, dryRun bool) ([]string, error) {
+	configFileLock.Lock()
+	defer configFileLock.Unlock()
+	cf, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := planConfigFile(cf, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var summaries []string
+	for _, change := range changes {
+		summaries = append(summaries, change.summary)
+		if dryRun {
+			continue
+		}
+		if err := change.apply(); err != nil {
+			return summaries, fmt.Errorf("%s: %w", change.summary, err)
+		}
+		log.Println("config file:", change.summary)
+	}
+	if !dryRun && len(changes) > 0 {
+		InvalidateChannelCache()
+	}
+	return summaries, nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/snowie2000/livetv/global"
+)
+
+type CookieJarInfo struct {
+	Name    string
+	Cookies int
+}
+
+func GetCookieJars() []CookieJarInfo {
+	names := global.CookieJarNames()
+	jars := make([]CookieJarInfo, 0, len(names))
+	for _, name := range names {
+		jars = append(jars, CookieJarInfo{
+			Name:    name,
+			Cookies: len(global.GetCookieJar(name).List()),
+		})
+	}
+	return jars
+}
+
+// ImportCookies adds cookies in the netscape cookies.txt format to a jar and returns how many were read
+func ImportCookies(name string, cookies string, replace bool) (int, error) {
+	return global.GetCookieJar(strings.TrimSpace(name)).Import(cookies, replace)
+}
+
+func ExportCookies(name string) string {
+	return global.GetCookieJar(name).Export()
+}
+
+func DeleteCookieJar(name string) error {
+	return global.DeleteCookieJar(name)
+}
@@ -58,21 +58,6 @@ func GetManifestHLS(vid string) (string, error) {
 }
 */
 
-func GetChannelLiveStream(channelName string) (string, error) {
-	service, err := getYoutubeService()
-	if err != nil {
-		return "", err
-	}
-	response, err := service.Search.List([]string{"snippet"}).ChannelId(channelName).EventType("live").Type("video").Do()
-	if err != nil {
-		return "", err
-	}
-	if len(response.Items) > 0 {
-		return response.Items[0].Id.VideoId, nil
-	}
-	return "", errors.New("This channel is not currently live")
-}
-
 func GetVideoDuration(url string) (float64, error) {
 	vid := GetYouTubeVideoID(url)
 	if vid == "" {
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+// external parsers are listed after scripts
+const execPriority = 200
+
+func execTimeout(p *model.ExecParser) time.Duration {
+	if p.Timeout > 0 {
+		return time.Duration(p.Timeout) * time.Second
+	}
+	return global.HttpClientTimeout
+}
+
+// LoadExecParsers registers all enabled external parsers from the database
+func LoadExecParsers() {
+	parsers, err := GetAllExecParsers()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, p := range parsers {
+		if !p.Enabled {
+			continue
+		}
+		if err := plugin.RegisterExec(p.Name, p.Command, p.Args, execTimeout(p), execPriority+p.ID); err != nil {
+			log.Println("failed to load external parser", p.Name, err)
+		}
+	}
+}
+
+func GetAllExecParsers() (parsers []*model.ExecParser, err error) {
+	err = global.DB.Find(&parsers).Error
+	return
+}
+
+func SaveExecParser(p *model.ExecParser) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Enabled {
+		if _, err := plugin.NewExecParser(p.Name, p.Command, p.Args, execTimeout(p)); err != nil {
+			return err
+		}
+		if err := plugin.CheckDynamicName(p.Name, plugin.ExecKind); err != nil {
+			return err
+		}
+	}
+	// scripts and exec parsers share one namespace, disabled ones included
+	if !global.DB.Where("name = ?", p.Name).First(&model.Script{}).RecordNotFound() {
+		return errors.New(p.Name + " is already used by a script")
+	}
+	var old model.ExecParser
+	if p.ID != 0 {
+		global.DB.Where("id = ?", p.ID).First(&old)
+	}
+	if err := global.DB.Save(p).Error; err != nil {
+		return err
+	}
+	if old.Name != "" {
+		plugin.UnregisterDynamic(old.Name, plugin.ExecKind)
+	}
+	if p.Enabled {
+		return plugin.RegisterExec(p.Name, p.Command, p.Args, execTimeout(p), execPriority+p.ID)
+	}
+	return nil
+}
+
+func DeleteExecParser(id int) error {
+	var p model.ExecParser
+	if err := global.DB.Where("id = ?", id).First(&p).Error; err != nil {
+		return err
+	}
+	plugin.UnregisterDynamic(p.Name, plugin.ExecKind)
+	return global.DB.Delete(model.ExecParser{}, "id = ?", id).Error
+}
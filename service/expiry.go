@@ -0,0 +1,78 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/snowie2000/livetv/model"
+)
+
+const (
+	minExpiryLead    = time.Minute
+	maxExpiryLead    = 15 * time.Minute
+	minExpiryReparse = 30 * time.Second
+)
+
+var (
+	expiryLock   sync.Mutex
+	expiryTimers = make(map[string]*time.Timer) // channel url => reparse timer
+)
+
+// a resolved url is considered dead slightly before its real expiry
+func isExpired(info *model.LiveInfo) bool {
+	return !info.ExpiresAt.IsZero() && time.Now().Add(minExpiryReparse).After(info.ExpiresAt)
+}
+
+// reparse earlier for long-lived urls, but never too early or too late
+func expiryLead(lifetime time.Duration) time.Duration {
+	lead := lifetime / 10
+	if lead < minExpiryLead {
+		lead = minExpiryLead
+	}
+	if lead > maxExpiryLead {
+		lead = maxExpiryLead
+	}
+	return lead
+}
+
+// schedule a reparse of the channel shortly before its resolved url expires
+func scheduleExpiryReparse(channel *model.Channel, info *model.LiveInfo) {
+	if info.ExpiresAt.IsZero() {
+		cancelExpiryReparse(channel.URL)
+		return
+	}
+	lifetime := time.Until(info.ExpiresAt)
+	delay := lifetime - expiryLead(lifetime)
+	if delay < minExpiryReparse {
+		delay = minExpiryReparse
+	}
+	ch := *channel
+	log.Println(ch.URL, "expires at", info.ExpiresAt.Format(time.DateTime), "reparse in", delay.Round(time.Second))
+	expiryLock.Lock()
+	defer expiryLock.Unlock()
+	if t, ok := expiryTimers[ch.URL]; ok {
+		t.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		expiryLock.Lock()
+		// a newer timer may have replaced this one, it must stay cancellable
+		if expiryTimers[ch.URL] == timer {
+			delete(expiryTimers, ch.URL)
+		}
+		expiryLock.Unlock()
+		log.Println(ch.URL, "is about to expire, reparsing")
+		UpdateURLCacheSingle(&ch, true)
+	})
+	expiryTimers[ch.URL] = timer
+}
+
+func cancelExpiryReparse(url string) {
+	expiryLock.Lock()
+	defer expiryLock.Unlock()
+	if t, ok := expiryTimers[url]; ok {
+		t.Stop()
+		delete(expiryTimers, url)
+	}
+}
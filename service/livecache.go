@@ -0,0 +1,74 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+)
+
+// entries expiring sooner than this are not worth restoring
+const minRestoredLifetime = time.Minute
+
+// when the restored entries were resolved, read once by LoadChannelCache
+var restoredAt map[string]time.Time
+
+// store a parse result in the url cache and the database
+func storeLiveInfo(url string, info *model.LiveInfo) {
+	global.URLCache.Store(url, info)
+	rec := model.LiveCache{
+		URL:        url,
+		LiveUrl:    info.LiveUrl,
+		Logo:       info.Logo,
+		ExtraInfo:  info.ExtraInfo,
+		Title:      info.Title,
+		ExpiresAt:  info.ExpiresAt,
+		ResolvedAt: time.Now(),
+	}
+	if err := global.DB.Save(&rec).Error; err != nil {
+		log.Println("failed to save the live url of", url, err)
+	}
+}
+
+// drop a parse result from the url cache and the database
+func deleteLiveInfo(url string) {
+	global.URLCache.Delete(url)
+	if err := global.DB.Delete(model.LiveCache{}, "url = ?", url).Error; err != nil {
+		log.Println("failed to delete the live url of", url, err)
+	}
+}
+
+// RestoreURLCache loads the live urls resolved before the last shutdown, so that channels play before they are parsed again
+func RestoreURLCache() {
+	var recs []model.LiveCache
+	if err := global.DB.Find(&recs).Error; err != nil {
+		log.Println("failed to load saved live urls:", err)
+		return
+	}
+	restoredAt = make(map[string]time.Time)
+	for _, rec := range recs {
+		if !rec.ExpiresAt.IsZero() && time.Until(rec.ExpiresAt) < minRestoredLifetime {
+			global.DB.Delete(model.LiveCache{}, "url = ?", rec.URL)
+			continue
+		}
+		global.URLCache.Store(rec.URL, &model.LiveInfo{
+			LiveUrl:   rec.LiveUrl,
+			Logo:      rec.Logo,
+			ExtraInfo: rec.ExtraInfo,
+			Title:     rec.Title,
+			ExpiresAt: rec.ExpiresAt,
+		})
+		UpdateStatus(rec.URL, Ok, "Restored")
+		restoredAt[rec.URL] = rec.ResolvedAt
+	}
+	// sub channels are listed from the restored results of their parents
+	InvalidateChannelCache()
+	log.Println(len(restoredAt), "live urls restored")
+}
+
+// whether a channel has a restored result that its schedule doesn't want refreshed yet
+func isFreshlyRestored(ch *model.Channel, now time.Time) bool {
+	resolvedAt, ok := restoredAt[ch.URL]
+	return ok && channelSchedule(ch).Next(resolvedAt).After(now)
+}
@@ -10,7 +10,8 @@ import (
 	"github.com/snowie2000/livetv/model"
 )
 
-func M3UGenerate() (string, error) {
+// M3UGenerate lists all channels, profileQuery is appended to every channel link
+func M3UGenerate(profileQuery string) (string, error) {
 	baseUrl, err := global.GetConfig("base_url")
 	if err != nil {
 		log.Println(err)
@@ -36,7 +37,7 @@ func M3UGenerate() (string, error) {
 		}
 		liveData := fmt.Sprintf("#EXTINF:-1, tvg-name=%s tvg-logo=%s group-title=%s, %s\n", strconv.Quote(ch.Name), strconv.Quote(logo), strconv.Quote(category), ch.Name)
 		m3u.WriteString(liveData)
-		m3u.WriteString(fmt.Sprintf("%s/live.m3u8?token=%s&c=%s\n", baseUrl, ch.Token, ch.ChannelID))
+		m3u.WriteString(fmt.Sprintf("%s/live.m3u8?token=%s&c=%s%s\n", baseUrl, ch.Token, ch.ChannelID, profileQuery))
 	}
 	m3u.WriteString("#EXTM3U\n")
 	for _, v := range channels {
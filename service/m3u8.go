@@ -3,8 +3,9 @@ package service
 import (
 	"bytes"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
-	"time"
 
 	"github.com/grafov/m3u8"
 
@@ -12,56 +13,116 @@ import (
 	"github.com/snowie2000/livetv/util"
 )
 
-var startUp int64 = 0
+// keys may use schemes like skd:// or data:, only http ones can be proxied
+func isFetchableUri(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && (u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https")
+}
 
-func PlaceHolderHLS() string {
-	// t := (time.Now().Unix() - startUp) / 60
-	baseUrl, _ := global.GetConfig("base_url")
-	if !strings.HasSuffix(baseUrl, "/") {
-		baseUrl += "/"
-	}
-	placeholder := baseUrl + "placeholder.ts"
-	tpl := `#EXTM3U
-#EXT-X-VERSION:3
-#EXT-X-MEDIA-SEQUENCE:1
-#EXT-X-TARGETDURATION:30
-#EXT-X-DISCONTINUITY:0
-#EXTINF:30.000000,
-%s?t=1
-#EXTINF:30.000000,
-%s?t=2
-#EXTINF:30.000000,
-%s?t=3
-#EXT-X-ENDLIST
-`
-	return fmt.Sprintf(tpl, placeholder, placeholder, placeholder)
+// the IV of an AES-128 segment, the media sequence number is used when the key has none
+func segmentIV(key *m3u8.Key, seqId uint64) string {
+	if key.IV != "" {
+		return strings.TrimPrefix(strings.TrimPrefix(key.IV, "0x"), "0X")
+	}
+	return fmt.Sprintf("%032x", seqId)
 }
 
-func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL string, proxyToken string, proxy bool, decrypt bool, channelNum int, fnTransform func(raw string, ts string) string) string {
 	baseUrl := global.GetBaseURL(playlistUrl)
+	resolveUri := func(uri string) string {
+		if !global.IsValidURL(uri) {
+			uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
+		}
+		return uri
+	}
+	proxyUri := func(uri string, query string) string {
+		tsLink := global.MergeUrl(prefixURL, fmt.Sprintf("live.ts?token=%s&k=%s&c=%d%s", proxyToken, util.CompressString(uri), channelNum, query))
+		if fnTransform != nil {
+			tsLink = fnTransform(uri, tsLink)
+		}
+		return tsLink
+	}
 	handleUri := func(uri string) string {
 		if uri == "" {
 			return uri
 		}
-		if !global.IsValidURL(uri) {
-			uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
-		}
+		uri = resolveUri(uri)
 		if proxy {
-			tsLink := global.MergeUrl(prefixURL, fmt.Sprintf("live.ts?token=%s&k=%s&c=%d", proxyToken, util.CompressString(uri), channelNum))
-			if fnTransform != nil {
-				tsLink = fnTransform(uri, tsLink)
-			}
-			uri = tsLink
+			uri = proxyUri(uri, "")
 		}
 		return uri
 	}
+	handleKeyUri := func(uri string) string {
+		if !isFetchableUri(uri) {
+			return uri
+		}
+		return handleUri(uri)
+	}
 
 	var i uint = 0
 	if pl.Count() >= pl.WinSize() && pl.WinSize() > 0 {
 		i = pl.Count() - pl.WinSize()
 	}
+	// a key or map tag applies to every following segment, find the ones in effect where the window starts
+	var currentKey *m3u8.Key
+	var currentMap *m3u8.Map
+	for j := uint(0); j <= i && j < pl.Count(); j++ {
+		if pl.Segments[j].Key != nil {
+			currentKey = pl.Segments[j].Key
+		}
+		if pl.Segments[j].Map != nil {
+			currentMap = pl.Segments[j].Map
+		}
+	}
+	if i < pl.Count() {
+		if pl.Segments[i].Key == nil && currentKey != nil {
+			key := *currentKey
+			pl.Segments[i].Key = &key
+		}
+		if pl.Segments[i].Map == nil && currentMap != nil {
+			m := *currentMap
+			pl.Segments[i].Map = &m
+		}
+	}
+	// decrypt on the proxy only if every key in the window is a plain AES-128 one
+	decrypt = decrypt && proxy
+	if decrypt {
+		for j := i; j < pl.Count(); j++ {
+			if key := pl.Segments[j].Key; key != nil && key.Method != "NONE" &&
+				(key.Method != "AES-128" || (key.Keyformat != "" && key.Keyformat != "identity") || !isFetchableUri(key.URI)) {
+				decrypt = false
+				break
+			}
+		}
+	}
+	if pl.Key != nil && pl.Key.URI != "" && !decrypt {
+		pl.Key.URI = handleKeyUri(pl.Key.URI)
+	}
+	if pl.Map != nil {
+		pl.Map.URI = handleUri(pl.Map.URI)
+	}
 	for ; i < pl.Count(); i++ {
-		pl.Segments[i].URI = handleUri(pl.Segments[i].URI)
+		seg := pl.Segments[i]
+		if seg.Map != nil {
+			seg.Map.URI = handleUri(seg.Map.URI)
+		}
+		if decrypt {
+			if seg.Key != nil {
+				currentKey = seg.Key
+				seg.Key = nil
+			}
+			if currentKey != nil && currentKey.Method == "AES-128" {
+				keyUri := resolveUri(currentKey.URI)
+				seg.URI = proxyUri(resolveUri(seg.URI), fmt.Sprintf("&key=%s&iv=%s", util.CompressString(keyUri), segmentIV(currentKey, seg.SeqId)))
+				continue
+			}
+		} else if seg.Key != nil && seg.Key.URI != "" {
+			seg.Key.URI = handleKeyUri(seg.Key.URI)
+		}
+		seg.URI = handleUri(seg.URI)
+	}
+	if decrypt {
+		pl.Key = nil // the client gets plain segments
 	}
 	// remove unused segments
 	for pl.Count() > pl.WinSize() && pl.WinSize() > 0 {
@@ -70,7 +131,12 @@ func processMediaPlaylist(playlistUrl string, pl *m3u8.MediaPlaylist, prefixURL
 	return pl.Encode().String()
 }
 
-func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixURL string, proxyToken string, proxy bool, pref RenditionPreference, channelNum int, fnTransform func(raw string, ts string) string) string {
+	if pref.Quality != nil {
+		selectVariant(pl, *pref.Quality)
+	}
+	selectRenditions(pl, "AUDIO", pref.Audio)
+	selectRenditions(pl, "SUBTITLES", pref.Subtitles)
 	baseUrl := global.GetBaseURL(playlistUrl)
 	handleUri := func(uri string) string {
 		if uri == "" {
@@ -100,19 +166,94 @@ func processMasterPlaylist(playlistUrl string, pl *m3u8.MasterPlaylist, prefixUR
 	return pl.Encode().String()
 }
 
-func M3U8Process(playlistUrl string, data string, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+var uriAttrRegex = regexp.MustCompile(`URI="([^"]*)"`)
+
+// grafov/m3u8 drops the tags of low latency hls, these playlists are rewritten line by line instead
+func isLowLatencyPlaylist(data string) bool {
+	return !strings.Contains(data, "#EXT-X-STREAM-INF") &&
+		(strings.Contains(data, "#EXT-X-PART:") || strings.Contains(data, "#EXT-X-PRELOAD-HINT:") || strings.Contains(data, "#EXT-X-SERVER-CONTROL:"))
+}
+
+// BlockingReloadKey returns the _HLS_ query parameters of a low latency playlist request, empty for a normal request
+func BlockingReloadKey(query url.Values) string {
+	hls := url.Values{}
+	for key, values := range query {
+		if strings.HasPrefix(key, "_HLS_") {
+			hls[key] = values
+		}
+	}
+	if len(hls) == 0 {
+		return ""
+	}
+	return "?" + hls.Encode()
+}
+
+func processLowLatencyPlaylist(playlistUrl string, data string, prefixURL string, proxyToken string, proxy bool, channelNum int, fnTransform func(raw string, ts string) string) string {
+	baseUrl := global.GetBaseURL(playlistUrl)
+	// route is live.ts for media and playlist.m3u8 for the playlists of rendition reports
+	handleUri := func(uri string, route string) string {
+		if uri == "" {
+			return uri
+		}
+		if !global.IsValidURL(uri) {
+			uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
+		}
+		if proxy {
+			link := global.MergeUrl(prefixURL, fmt.Sprintf("%s?token=%s&k=%s&c=%d", route, proxyToken, util.CompressString(uri), channelNum))
+			if fnTransform != nil {
+				link = fnTransform(uri, link)
+			}
+			uri = link
+		}
+		return uri
+	}
+	replaceUriAttr := func(line string, route string) string {
+		return uriAttrRegex.ReplaceAllStringFunc(line, func(attr string) string {
+			uri := uriAttrRegex.FindStringSubmatch(attr)[1]
+			if !isFetchableUri(uri) {
+				return attr
+			}
+			return `URI="` + handleUri(uri, route) + `"`
+		})
+	}
+
+	var sb strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case !strings.HasPrefix(line, "#"):
+			line = handleUri(line, "live.ts")
+		case strings.HasPrefix(line, "#EXT-X-RENDITION-REPORT:"):
+			line = replaceUriAttr(line, "playlist.m3u8")
+		case strings.HasPrefix(line, "#EXT-X-PART:"), strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"),
+			strings.HasPrefix(line, "#EXT-X-MAP:"), strings.HasPrefix(line, "#EXT-X-KEY:"):
+			line = replaceUriAttr(line, "live.ts")
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// M3U8Process rewrites the links of a playlist, through the proxy if proxy is set.
+// decrypt makes the proxy decrypt AES-128 segments for clients that can't fetch the keys,
+// pref chooses the variants and renditions kept in a master playlist, filter drops the ads of a media playlist.
+func M3U8Process(playlistUrl string, data string, prefixURL string, proxyToken string, proxy bool, decrypt bool, pref RenditionPreference, filter *AdFilter, channelNum int, fnTransform func(raw string, ts string) string) string {
+	if isLowLatencyPlaylist(data) {
+		return processLowLatencyPlaylist(playlistUrl, data, prefixURL, proxyToken, proxy, channelNum, fnTransform) // keys are proxied but not decrypted
+	}
+	if !strings.Contains(data, "#EXT-X-STREAM-INF") {
+		data = filter.Apply(adFilterKey(channelNum, playlistUrl), data) // after the low latency branch, it isn't filtered
+	}
 	p, listType, err := m3u8.DecodeFrom(bytes.NewBufferString(data), false)
 	if err == nil {
 		switch listType {
 		case m3u8.MASTER:
-			return processMasterPlaylist(playlistUrl, p.(*m3u8.MasterPlaylist), prefixURL, proxyToken, proxy, channelNum, fnTransform)
+			return processMasterPlaylist(playlistUrl, p.(*m3u8.MasterPlaylist), prefixURL, proxyToken, proxy, pref, channelNum, fnTransform)
 		case m3u8.MEDIA:
-			return processMediaPlaylist(playlistUrl, p.(*m3u8.MediaPlaylist), prefixURL, proxyToken, proxy, channelNum, fnTransform)
+			return processMediaPlaylist(playlistUrl, p.(*m3u8.MediaPlaylist), prefixURL, proxyToken, proxy, decrypt, channelNum, fnTransform)
 		}
 	}
 	return ""
 }
-
-func init() {
-	startUp = time.Now().Unix()
-}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/grafov/m3u8"
+	"github.com/snowie2000/livetv/util"
+)
+
+func TestSegmentIV(t *testing.T) {
+	tests := []struct {
+		iv    string
+		seqId uint64
+		want  string
+	}{
+		{"0x000102030405060708090A0B0C0D0E0F", 5, "000102030405060708090A0B0C0D0E0F"},
+		{"0X0f0e0d0c0b0a09080706050403020100", 5, "0f0e0d0c0b0a09080706050403020100"},
+		{"", 0, "00000000000000000000000000000000"},
+		{"", 1234567, "0000000000000000000000000012d687"},
+	}
+	for _, tt := range tests {
+		if got := segmentIV(&m3u8.Key{Method: "AES-128", IV: tt.iv}, tt.seqId); got != tt.want {
+			t.Errorf("segmentIV(%q, %d) = %s, want %s", tt.iv, tt.seqId, got, tt.want)
+		}
+	}
+}
+
+// without an IV attribute a segment is encrypted with its media sequence number as the IV
+func TestDecryptWithSequenceIV(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	const seqId = 987654321
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], seqId)
+	plain := bytes.Repeat([]byte{0x47, 0x00, 0x11, 0x10}, 47)
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{4}, 4)...)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	derived, err := hex.DecodeString(segmentIV(&m3u8.Key{Method: "AES-128"}, seqId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := util.DecryptAES128(padded, key, derived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted segment differs")
+	}
+}
@@ -1,33 +1,140 @@
 package service
 
 import (
+	"context"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/LgoLgo/geentrant"
 	"github.com/snowie2000/livetv/global"
 	"github.com/snowie2000/livetv/model"
 	"github.com/snowie2000/livetv/plugin"
+	"github.com/snowie2000/livetv/syncx"
 )
 
-var updateConcurrent = &greetrant.RecursiveMutex{}
-var parseCanceler func(chId int) = nil
+type parseContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// parent of all parse contexts, cancelled on shutdown
+var rootCtx, shutdown = context.WithCancel(context.Background())
+
+var (
+	parseGroup   syncx.Group[string, *model.LiveInfo] // coalesce concurrent parses of the same channel
+	parseLock    sync.Mutex
+	parseCancels = make(map[int]*parseContext) // root channel id => context shared by the channel and its sub channels
+	parseSlots   struct {
+		sync.Mutex
+		size int
+		ch   chan struct{}
+	}
+)
+
+// the parse worker pool, replaced when parse_workers changes. Parses already running finish in the pool they started in.
+func parsePool() chan struct{} {
+	sWorkers, _ := global.GetConfig("parse_workers")
+	workers, _ := strconv.Atoi(sWorkers)
+	if workers <= 0 {
+		workers = 1
+	}
+	parseSlots.Lock()
+	defer parseSlots.Unlock()
+	if parseSlots.ch == nil || parseSlots.size != workers {
+		parseSlots.ch = make(chan struct{}, workers)
+		parseSlots.size = workers
+	}
+	return parseSlots.ch
+}
+
+// sub channels share the context of their parent, so deleting the parent cancels them all
+func rootChannelID(channel *model.Channel) int {
+	if channel.ChannelID == "" {
+		return channel.ID
+	}
+	id, _ := strconv.Atoi(strings.SplitN(channel.ChannelID, "-", 2)[0])
+	return id
+}
+
+func channelContext(channel *model.Channel) context.Context {
+	id := rootChannelID(channel)
+	if id <= 0 {
+		return rootCtx // temporary channels can't be deleted
+	}
+	parseLock.Lock()
+	defer parseLock.Unlock()
+	pc, ok := parseCancels[id]
+	if !ok {
+		ctx, cancel := context.WithCancel(rootCtx)
+		pc = &parseContext{ctx, cancel}
+		parseCancels[id] = pc
+	}
+	return pc.ctx
+}
 
 // cancel the parser if the channel or its sub channel is being parsed.
 func CancelChannelParser(chId int) {
-	if parseCanceler != nil {
-		parseCanceler(chId)
+	parseLock.Lock()
+	defer parseLock.Unlock()
+	if pc, ok := parseCancels[chId]; ok {
+		pc.cancel()
+		delete(parseCancels, chId)
 	}
 }
 
+// Shutdown cancels all running parses
+func Shutdown() {
+	shutdown()
+}
+
+// the timeout of a single parse: the channel's own setting, or the parser default
+func channelTimeout(channel *model.Channel) time.Duration {
+	if channel.Timeout > 0 {
+		return time.Duration(channel.Timeout) * time.Second
+	}
+	if p, err := plugin.GetPlugin(channel.Parser); err == nil {
+		return plugin.ParseTimeout(p)
+	}
+	return global.HttpClientTimeout
+}
+
+// refresh channels in parallel, bounded by the parse worker pool
+func updateChannels(channels []*model.Channel, bUpdateStatus bool) {
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch *model.Channel) {
+			defer wg.Done()
+			UpdateURLCacheSingle(ch, bUpdateStatus)
+		}(ch)
+	}
+	wg.Wait()
+}
+
+// parse all channels at startup, the ones restored from the last run wait until their schedule says they're stale
 func LoadChannelCache() {
 	channels, err := GetAllChannel()
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	for _, v := range channels {
-		UpdateURLCacheSingle(v, true)
+	now := time.Now()
+	var stale []*model.Channel
+	for _, ch := range channels {
+		if isFreshlyRestored(ch, now) {
+			if info, ok := global.URLCache.Load(ch.URL); ok {
+				scheduleExpiryReparse(ch, info)
+			}
+			continue
+		}
+		stale = append(stale, ch)
+	}
+	if len(stale) < len(channels) {
+		log.Println(len(channels)-len(stale), "channels are playing from restored urls,", len(stale), "to parse")
 	}
+	updateChannels(stale, true)
 	InvalidateChannelCache()
 }
 
@@ -35,76 +142,72 @@ func UpdateSubChannels(parentChannel *model.Channel, liveInfo *model.LiveInfo, P
 	// let's check if there are any sub channels
 	if p, err := plugin.GetPlugin(Parser); err == nil {
 		if provider, ok := p.(plugin.ChannalProvider); ok {
-			subchannels := provider.Channels(parentChannel, liveInfo)
-			canceled := false
-			if len(subchannels) > 0 {
-				// create a canceler
-				parseCanceler = func(chId int) {
-					if chId == parentChannel.ID {
-						canceled = true
-					}
-				}
-				defer func() {
-					parseCanceler = nil
-				}()
-			}
-			for _, ch := range subchannels {
-				if canceled {
-					log.Println("Channel has been removed")
-					break
+			subchannels := []*model.Channel{}
+			for _, ch := range provider.Channels(parentChannel, liveInfo) {
+				if ch.URL != parentChannel.URL { // a playlist including itself would wait for itself forever
+					subchannels = append(subchannels, ch)
 				}
-				UpdateURLCacheSingle(ch, bUpdateStatus)
 			}
+			updateChannels(subchannels, bUpdateStatus)
 		}
 	}
 }
 
-func UpdateURLCacheSingle(channel *model.Channel, bUpdateStatus bool) (*model.LiveInfo, error) {
-	updateConcurrent.Lock()
-	defer func() {
-		updateConcurrent.Unlock()
-	}()
+// run the parser in a worker slot, the slot is released before sub channels are parsed
+func parseInSlot(ctx context.Context, channel *model.Channel) (*model.LiveInfo, error) {
+	slots := parsePool()
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-slots }()
 	log.Println("caching", channel.URL)
-	liveInfo, err := RealLiveM3U8(channel.URL, channel.ProxyUrl, channel.Parser)
-	if err != nil {
-		global.URLCache.Delete(channel.URL)
-		UpdateStatus(channel.URL, Error, err.Error())
-		log.Println("[LiveTV]", err)
-	} else {
-		// cache parsed result
-		global.URLCache.Store(channel.URL, liveInfo)
-		if bUpdateStatus {
-			UpdateStatus(channel.URL, Ok, "Live!")
-		}
-		log.Println(channel.URL, "cached")
+	ctx, cancel := context.WithTimeout(plugin.WithChannel(ctx, channel), channelTimeout(channel))
+	defer cancel()
+	return RealLiveM3U8(ctx, channel.URL, channel.ProxyUrl, channel.Parser)
+}
 
-		InvalidateChannelCache(channel.ChannelID)
-		UpdateSubChannels(channel, liveInfo, channel.Parser, bUpdateStatus)
-	}
-	return liveInfo, err
+func UpdateURLCacheSingle(channel *model.Channel, bUpdateStatus bool) (*model.LiveInfo, error) {
+	return UpdateURLCacheContext(context.Background(), channel, bUpdateStatus)
 }
 
-func UpdateURLCache() {
-	channels, err := GetAllChannel()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	urlcache := make(map[string]bool)
-	for _, v := range channels {
-		urlcache[v.URL] = true
-	}
-	// delete urlcaches that we do not serve anymore
-	global.URLCache.Range(func(k string, info *model.LiveInfo) bool {
-		if _, ok := urlcache[k]; !ok {
-			global.URLCache.Delete(k)
-			DeleteStatus(k)
-			return true
+// channels share a parse when they would parse alike
+func parseKey(channel *model.Channel) string {
+	return strings.Join([]string{channel.URL, channel.Parser, channel.ProxyUrl, channel.ParserConfig}, "\x00")
+}
+
+// parse a channel and update the cache. The parse is shared by the callers of the same channel and only cancelled when
+// the channel is deleted, ctx only limits how long the caller waits for it.
+func UpdateURLCacheContext(ctx context.Context, channel *model.Channel, bUpdateStatus bool) (*model.LiveInfo, error) {
+	liveInfo, err, _ := parseGroup.DoContext(ctx, parseKey(channel), func() (*model.LiveInfo, error) {
+		ctx := channelContext(channel)
+		liveInfo, err := parseInSlot(ctx, channel)
+		if ctx.Err() != nil {
+			log.Println("Parse of", channel.URL, "cancelled")
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			deleteLiveInfo(channel.URL)
+			cancelExpiryReparse(channel.URL)
+			UpdateStatus(channel.URL, Error, err.Error())
+			log.Println("[LiveTV]", err)
+		} else {
+			// cache parsed result
+			oldInfo, _ := global.URLCache.Load(channel.URL)
+			storeLiveInfo(channel.URL, liveInfo)
+			notifyChildrenChange(channel, oldInfo, liveInfo)
+			scheduleExpiryReparse(channel, liveInfo)
+			if bUpdateStatus {
+				UpdateStatus(channel.URL, Ok, "Live!")
+			}
+			log.Println(channel.URL, "cached")
+			InvalidateChannelCache(channel.ChannelID)
+			// walked by the shared parse, so that they are refreshed even if every caller stopped waiting.
+			// Callers don't wait for them, a sub channel listing its parent would wait for itself.
+			go UpdateSubChannels(channel, liveInfo, channel.Parser, bUpdateStatus)
 		}
-		return true
+		return liveInfo, err
 	})
-	for _, v := range channels {
-		UpdateURLCacheSingle(v, true)
-	}
-	InvalidateChannelCache()
+	return liveInfo, err
 }
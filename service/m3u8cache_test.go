@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/snowie2000/livetv/global"
+)
+
+func TestParsePoolFollowsSetting(t *testing.T) {
+	old, _ := global.ConfigCache.Load("parse_workers")
+	t.Cleanup(func() { global.ConfigCache.Store("parse_workers", old) })
+
+	global.ConfigCache.Store("parse_workers", "2")
+	pool := parsePool()
+	if cap(pool) != 2 {
+		t.Fatalf("pool of %d workers, want 2", cap(pool))
+	}
+	if parsePool() != pool {
+		t.Error("the pool was replaced without a change of the setting")
+	}
+	global.ConfigCache.Store("parse_workers", "6")
+	if cap(parsePool()) != 6 {
+		t.Errorf("pool of %d workers after the setting changed, want 6", cap(parsePool()))
+	}
+	global.ConfigCache.Store("parse_workers", "0")
+	if cap(parsePool()) != 1 {
+		t.Errorf("pool of %d workers for an invalid setting, want 1", cap(parsePool()))
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/grafov/m3u8"
+
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+// RenditionPreference chooses among the variants and renditions of a master playlist
+type RenditionPreference struct {
+	Quality   *plugin.Quality // only the chosen variant is kept when set
+	Audio     []string        // preferred audio languages, in order
+	Subtitles []string        // preferred subtitle languages, in order
+}
+
+// query parameters that let a client ask for other languages than the channel's, like a profile
+var profileParams = []string{"audio", "subtitle"}
+
+// ParseLanguages splits a language list like "ja, en" into lower case tags
+func ParseLanguages(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ',' || r == ' ' || r == ';' })
+}
+
+// ChannelRenditionPreference returns the preference of a channel, overridden by the audio and subtitle parameters of the request
+func ChannelRenditionPreference(channel *model.Channel, query url.Values) RenditionPreference {
+	pref := RenditionPreference{
+		Audio:     ParseLanguages(channel.AudioLang),
+		Subtitles: ParseLanguages(channel.SubsLang),
+	}
+	if channel.Quality != "" {
+		if q, err := plugin.ParseQuality(channel.Quality); err == nil {
+			pref.Quality = &q
+		}
+	}
+	if query.Has("audio") {
+		pref.Audio = ParseLanguages(query.Get("audio"))
+	}
+	if query.Has("subtitle") {
+		pref.Subtitles = ParseLanguages(query.Get("subtitle"))
+	}
+	return pref
+}
+
+// ProfileQuery returns the profile parameters of a request as "&audio=..", to be passed on to channel links
+func ProfileQuery(query url.Values) string {
+	profile := url.Values{}
+	for _, key := range profileParams {
+		if query.Has(key) {
+			profile.Set(key, query.Get(key))
+		}
+	}
+	if len(profile) == 0 {
+		return ""
+	}
+	return "&" + profile.Encode()
+}
+
+// "en" matches "en", "EN" and "en-US"
+func matchLanguage(lang string, pref string) bool {
+	lang = strings.ToLower(lang)
+	return lang == pref || strings.HasPrefix(lang, pref+"-")
+}
+
+// the position of a language in the preference list, -1 if not preferred
+func languageRank(lang string, prefs []string) int {
+	for i, pref := range prefs {
+		if matchLanguage(lang, pref) {
+			return i
+		}
+	}
+	return -1
+}
+
+// keep only the variant the quality asks for, i-frame variants are left alone
+func selectVariant(pl *m3u8.MasterPlaylist, quality plugin.Quality) {
+	var streams []*m3u8.Variant
+	for _, v := range pl.Variants {
+		if !v.Iframe {
+			streams = append(streams, v)
+		}
+	}
+	if len(streams) < 2 {
+		return
+	}
+	selected := quality.Pick(len(streams),
+		func(i int) int { return plugin.ResolutionHeight(streams[i].Resolution) },
+		func(i int) float64 { return float64(streams[i].Bandwidth) })
+	// grafov/m3u8 attaches the EXT-X-MEDIA tags to whichever variant follows them, move them to the one we keep
+	var alternatives []*m3u8.Alternative
+	for _, v := range streams {
+		alternatives = append(alternatives, v.Alternatives...)
+	}
+	variants := []*m3u8.Variant{streams[selected]}
+	streams[selected].Alternatives = alternatives
+	for _, v := range pl.Variants {
+		if v.Iframe {
+			variants = append(variants, v)
+		}
+	}
+	pl.Variants = variants
+}
+
+// keep the renditions in the preferred languages, with DEFAULT=YES on the most preferred one.
+// Groups without any preferred language are left as they are.
+func selectRenditions(pl *m3u8.MasterPlaylist, mediaType string, prefs []string) {
+	if len(prefs) == 0 {
+		return
+	}
+	best := make(map[string]*m3u8.Alternative) // group id => default rendition
+	bestRank := make(map[string]int)
+	for _, v := range pl.Variants {
+		for _, alt := range v.Alternatives {
+			if alt.Type != mediaType {
+				continue
+			}
+			rank := languageRank(alt.Language, prefs)
+			if rank < 0 {
+				continue
+			}
+			if r, ok := bestRank[alt.GroupId]; !ok || rank < r {
+				best[alt.GroupId] = alt
+				bestRank[alt.GroupId] = rank
+			}
+		}
+	}
+	for _, v := range pl.Variants {
+		kept := v.Alternatives[:0]
+		for _, alt := range v.Alternatives {
+			if alt.Type == mediaType && best[alt.GroupId] != nil {
+				if languageRank(alt.Language, prefs) < 0 {
+					continue
+				}
+				alt.Default = alt == best[alt.GroupId]
+				if alt.Default {
+					alt.Autoselect = "YES" // required along with DEFAULT=YES
+				}
+			}
+			kept = append(kept, alt)
+		}
+		v.Alternatives = kept
+	}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+const (
+	minRefreshInterval = time.Minute
+	maxRefreshJitter   = 5 * time.Minute
+	schedulerSyncEvery = time.Hour
+)
+
+type scheduleEntry struct {
+	channel  *model.Channel
+	schedule cron.Schedule
+	next     time.Time
+}
+
+var scheduler = struct {
+	lock    sync.Mutex
+	entries map[int]*scheduleEntry
+	wake    chan struct{}
+}{
+	entries: make(map[int]*scheduleEntry),
+	wake:    make(chan struct{}, 1),
+}
+
+// ParseSchedule accepts a plain interval like "30m" or a cron expression like "0 */2 * * *" / "@every 1h"
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < minRefreshInterval {
+			return nil, errors.New("Refresh interval is too short")
+		}
+		return cron.Every(d), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	if every, ok := schedule.(cron.ConstantDelaySchedule); ok && every.Delay < minRefreshInterval {
+		return nil, errors.New("Refresh interval is too short")
+	}
+	return schedule, nil
+}
+
+// the schedule of a channel: its own setting, then the parser default, then the global default
+func channelSchedule(ch *model.Channel) cron.Schedule {
+	specs := []string{ch.Schedule}
+	if p, err := plugin.GetPlugin(ch.Parser); err == nil {
+		if s, ok := p.(plugin.Scheduler); ok {
+			specs = append(specs, s.Schedule())
+		}
+	}
+	if spec, err := global.GetConfig("refresh_schedule"); err == nil {
+		specs = append(specs, spec)
+	}
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		if schedule, err := ParseSchedule(spec); err == nil {
+			return schedule
+		} else {
+			log.Println("invalid schedule", spec, "for channel", ch.Name, err)
+		}
+	}
+	return cron.Every(3 * time.Hour)
+}
+
+// spread refreshes sharing the same schedule so that they don't fire all at once
+func nextWithJitter(schedule cron.Schedule, now time.Time) time.Time {
+	next := schedule.Next(now)
+	jitter := next.Sub(now) / 10
+	if jitter > maxRefreshJitter {
+		jitter = maxRefreshJitter
+	}
+	if jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	return next
+}
+
+func wakeScheduler() {
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add or update the refresh schedule of a channel
+func ScheduleChannel(ch *model.Channel) {
+	schedule := channelSchedule(ch)
+	scheduler.lock.Lock()
+	scheduler.entries[ch.ID] = &scheduleEntry{
+		channel:  ch,
+		schedule: schedule,
+		next:     nextWithJitter(schedule, time.Now()),
+	}
+	scheduler.lock.Unlock()
+	wakeScheduler()
+}
+
+// RescheduleDefaultChannels moves the channels without a schedule of their own to a new refresh_schedule
+func RescheduleDefaultChannels() {
+	var channels []*model.Channel
+	scheduler.lock.Lock()
+	for _, e := range scheduler.entries {
+		if e.channel.Schedule == "" {
+			channels = append(channels, e.channel)
+		}
+	}
+	scheduler.lock.Unlock()
+	for _, ch := range channels {
+		ScheduleChannel(ch)
+	}
+}
+
+func UnscheduleChannel(id int) {
+	scheduler.lock.Lock()
+	delete(scheduler.entries, id)
+	scheduler.lock.Unlock()
+}
+
+// returns the time of the next scheduled refresh, zero time if the channel is not scheduled
+func NextRefresh(id int) time.Time {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if e, ok := scheduler.entries[id]; ok {
+		return e.next
+	}
+	return time.Time{}
+}
+
+// collect channels that are due and advance their schedule, returns how long to sleep afterwards
+func dueChannels(now time.Time) ([]*model.Channel, time.Duration) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	var due []*model.Channel
+	wait := schedulerSyncEvery
+	for _, e := range scheduler.entries {
+		if !e.next.After(now) {
+			due = append(due, e.channel)
+			e.next = nextWithJitter(e.schedule, now)
+		}
+		if d := e.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return due, wait
+}
+
+func refreshChannel(ch *model.Channel) {
+	UpdateURLCacheSingle(ch, true)
+	if ch.HasSubChannel {
+		InvalidateChannelCache()
+	}
+}
+
+// reload all channels from the database and drop caches of channels that no longer exist
+func syncSchedules() {
+	channels, err := GetAllChannel()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	urlcache := make(map[string]bool)
+	ids := make(map[int]bool)
+	for _, v := range channels {
+		urlcache[v.URL] = true
+		ids[v.ID] = true
+		for _, sub := range v.Children {
+			urlcache[sub.URL] = true
+		}
+	}
+	// delete urlcaches that we do not serve anymore
+	global.URLCache.Range(func(k string, info *model.LiveInfo) bool {
+		if _, ok := urlcache[k]; !ok {
+			deleteLiveInfo(k)
+			DeleteStatus(k)
+		}
+		return true
+	})
+	scheduler.lock.Lock()
+	for id := range scheduler.entries {
+		if !ids[id] {
+			delete(scheduler.entries, id)
+		}
+	}
+	scheduler.lock.Unlock()
+	for _, v := range channels {
+		scheduler.lock.Lock()
+		e, ok := scheduler.entries[v.ID]
+		scheduler.lock.Unlock()
+		if !ok || e.channel.Schedule != v.Schedule || e.channel.URL != v.URL || e.channel.Parser != v.Parser {
+			ScheduleChannel(v)
+		}
+	}
+}
+
+// StartScheduler replaces the global refresh cron with a schedule per channel
+func StartScheduler() {
+	syncSchedules()
+	go func() {
+		lastSync := time.Now()
+		for {
+			now := time.Now()
+			if now.Sub(lastSync) >= schedulerSyncEvery {
+				syncSchedules()
+				lastSync = now
+			}
+			due, wait := dueChannels(now)
+			for _, ch := range due {
+				log.Println("scheduled refresh of", ch.Name)
+				go refreshChannel(ch)
+			}
+			select {
+			case <-time.After(wait):
+			case <-scheduler.wake:
+			}
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+// scripts are listed after all built-in parsers
+const scriptPriority = 100
+
+// LoadScripts registers all enabled parser scripts from the database
+func LoadScripts() {
+	scripts, err := GetAllScripts()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, s := range scripts {
+		if !s.Enabled {
+			continue
+		}
+		if err := plugin.RegisterScript(s.Name, s.Code, scriptPriority+s.ID); err != nil {
+			log.Println("failed to load script", s.Name, err)
+		}
+	}
+}
+
+// whether an enabled script is named name, whether or not it has been registered
+func isEnabledScript(name string) bool {
+	return !global.DB.Where(&model.Script{Name: name, Enabled: true}).First(&model.Script{}).RecordNotFound()
+}
+
+func GetAllScripts() (scripts []*model.Script, err error) {
+	err = global.DB.Find(&scripts).Error
+	return
+}
+
+// SaveScript validates the script and saves it before (re)registering it, so that a script that fails either is left as it was
+func SaveScript(script *model.Script) error {
+	script.Name = strings.TrimSpace(script.Name)
+	if script.Enabled {
+		if _, err := plugin.NewScriptParser(script.Name, script.Code); err != nil {
+			return err
+		}
+		if err := plugin.CheckDynamicName(script.Name, plugin.ScriptKind); err != nil {
+			return err
+		}
+	}
+	// scripts and exec parsers share one namespace, disabled ones included
+	if !global.DB.Where("name = ?", script.Name).First(&model.ExecParser{}).RecordNotFound() {
+		return errors.New(script.Name + " is already used by an exec parser")
+	}
+	var old model.Script
+	if script.ID != 0 {
+		global.DB.Where("id = ?", script.ID).First(&old)
+	}
+	if err := global.DB.Save(script).Error; err != nil {
+		return err
+	}
+	if old.Name != "" {
+		plugin.UnregisterDynamic(old.Name, plugin.ScriptKind)
+	}
+	var err error
+	if script.Enabled {
+		err = plugin.RegisterScript(script.Name, script.Code, scriptPriority+script.ID)
+	}
+	if err == nil {
+		// cached results were produced by the old code
+		global.URLCache.Range(func(key string, info *model.LiveInfo) bool {
+			if ch, ok := channelByURL(key); ok && ch.Parser == script.Name {
+				deleteLiveInfo(key)
+			}
+			return true
+		})
+	}
+	return err
+}
+
+func DeleteScript(id int) error {
+	var script model.Script
+	if err := global.DB.Where("id = ?", id).First(&script).Error; err != nil {
+		return err
+	}
+	plugin.UnregisterDynamic(script.Name, plugin.ScriptKind)
+	return global.DB.Delete(model.Script{}, "id = ?", id).Error
+}
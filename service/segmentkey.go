@@ -0,0 +1,44 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/snowie2000/livetv/global"
+)
+
+// keys are shared by many segments and rotate slowly, don't fetch them for every segment
+var segmentKeys = cache.New(time.Minute, 5*time.Minute)
+
+// SegmentKey fetches the AES-128 key of a segment with the same client and headers as the segment
+func SegmentKey(client *http.Client, req *http.Request, keyUrl string) ([]byte, error) {
+	if key, ok := segmentKeys.Get(keyUrl); ok {
+		return key.([]byte), nil
+	}
+	keyReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, keyUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	keyReq.Header = req.Header.Clone()
+	keyReq.Header.Del("Range")
+	resp, err := client.Do(keyReq)
+	if err != nil {
+		return nil, err
+	}
+	defer global.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key server response: HTTP %d", resp.StatusCode)
+	}
+	key, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("invalid key size %d", len(key))
+	}
+	segmentKeys.Set(keyUrl, key, cache.DefaultExpiration)
+	return key, nil
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nareix/joy5/av"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+	"github.com/snowie2000/livetv/syncx"
+	"github.com/snowie2000/livetv/util"
+)
+
+const (
+	BuiltinSlate = "builtin"
+	NoSlate      = "none"
+	// segments in the looping slate playlist
+	slateWindow = 3
+	// a channel that stopped asking for its slate this long ago starts over when it goes offline again
+	slateSessionTimeout = 2 * time.Minute
+)
+
+type SlateInfo struct {
+	Name     string
+	Duration float64 // seconds
+	Size     int
+}
+
+type slateClip struct {
+	data    []byte
+	info    SlateInfo
+	modTime time.Time
+}
+
+// when a client started watching the slate of a channel, and when it last asked for it
+type slateSession struct {
+	since time.Time
+	last  time.Time
+	first int // sequence of the first slate segment, past anything the channel served before
+	end   int // sequence of the last slate segment served
+}
+
+// how the playlist of a channel that came back from its slate is renumbered, so that its sequences continue
+// after the last slate segment instead of jumping back to wherever the source happens to be
+type slateHandover struct {
+	next        int    // the number the first real segment gets, past the last slate segment
+	first       uint64 // the media sequence of that segment in the source playlist
+	source      uint64 // the media sequence of the last source playlist
+	started     bool   // whether first and source are known yet
+	mediaOffset int
+	discOffset  int
+	served      int // the highest media sequence served so far
+	last        time.Time
+	lock        sync.Mutex
+}
+
+var (
+	builtinSlate   *slateClip
+	slateLock      sync.Mutex
+	slateClips     = make(map[string]*slateClip)
+	slateSessions  syncx.Map[string, slateSession]
+	slateHandovers syncx.Map[string, *slateHandover]
+	slateNameRegex = regexp.MustCompile(`^[\w.-]+$`)
+)
+
+// SetBuiltinSlate registers the "channel offline" clip shipped with livetv
+func SetBuiltinSlate(data []byte) error {
+	duration, err := util.TSDuration(data)
+	if err != nil {
+		return err
+	}
+	builtinSlate = &slateClip{data: data, info: SlateInfo{Name: BuiltinSlate, Duration: duration, Size: len(data)}}
+	return nil
+}
+
+func slateDir() string {
+	return filepath.Join(os.Getenv("LIVETV_DATADIR"), "slates")
+}
+
+func slatePath(name string) (string, error) {
+	if !slateNameRegex.MatchString(name) || name == BuiltinSlate || name == NoSlate {
+		return "", errors.New("invalid slate name")
+	}
+	return filepath.Join(slateDir(), name+".ts"), nil
+}
+
+// LoadSlate returns the content of a slate, uploaded clips are cached until they change
+func LoadSlate(name string) ([]byte, SlateInfo, error) {
+	if name == BuiltinSlate {
+		if builtinSlate == nil {
+			return nil, SlateInfo{}, errors.New("no builtin slate")
+		}
+		return builtinSlate.data, builtinSlate.info, nil
+	}
+	path, err := slatePath(name)
+	if err != nil {
+		return nil, SlateInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, SlateInfo{}, errors.New("slate " + name + " not found")
+	}
+	if err != nil {
+		return nil, SlateInfo{}, err
+	}
+	slateLock.Lock()
+	defer slateLock.Unlock()
+	if clip, ok := slateClips[name]; ok && clip.modTime.Equal(fi.ModTime()) {
+		return clip.data, clip.info, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, SlateInfo{}, err
+	}
+	duration, err := util.TSDuration(data)
+	if err != nil {
+		return nil, SlateInfo{}, err
+	}
+	clip := &slateClip{data: data, info: SlateInfo{Name: name, Duration: duration, Size: len(data)}, modTime: fi.ModTime()}
+	slateClips[name] = clip
+	return clip.data, clip.info, nil
+}
+
+// GetSlates lists the builtin and the uploaded slates
+func GetSlates() []SlateInfo {
+	var list []SlateInfo
+	if builtinSlate != nil {
+		list = append(list, builtinSlate.info)
+	}
+	files, _ := filepath.Glob(filepath.Join(slateDir(), "*.ts"))
+	sort.Strings(files)
+	for _, f := range files {
+		if _, info, err := LoadSlate(strings.TrimSuffix(filepath.Base(f), ".ts")); err == nil {
+			list = append(list, info)
+		}
+	}
+	return list
+}
+
+// SaveSlate stores an uploaded clip, mp4 files are remuxed to MPEG-TS so that they can be played as hls segments
+func SaveSlate(name string, data []byte) (SlateInfo, error) {
+	path, err := slatePath(name)
+	if err != nil {
+		return SlateInfo{}, err
+	}
+	if len(data) > 8 && string(data[4:8]) == "ftyp" {
+		packets, err := util.DemuxMP4(data)
+		if err != nil {
+			return SlateInfo{}, err
+		}
+		hasVideo, hasAudio := false, false
+		for _, p := range packets {
+			hasVideo = hasVideo || p.Type == av.H264DecoderConfig
+			hasAudio = hasAudio || p.Type == av.AACDecoderConfig
+		}
+		buf := &bytes.Buffer{}
+		muxer := plugin.NewTSMuxer(buf, hasVideo, hasAudio)
+		for _, p := range packets {
+			if err := muxer.WritePacket(p); err != nil {
+				return SlateInfo{}, err
+			}
+		}
+		data = buf.Bytes()
+	}
+	duration, err := util.TSDuration(data)
+	if err != nil {
+		return SlateInfo{}, err
+	}
+	if err := os.MkdirAll(slateDir(), os.ModePerm); err != nil {
+		return SlateInfo{}, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return SlateInfo{}, err
+	}
+	return SlateInfo{Name: name, Duration: duration, Size: len(data)}, nil
+}
+
+func DeleteSlate(name string) error {
+	path, err := slatePath(name)
+	if err != nil {
+		return err
+	}
+	slateLock.Lock()
+	delete(slateClips, name)
+	slateLock.Unlock()
+	return os.Remove(path)
+}
+
+// CheckSlate makes sure a slate setting names a slate that can be played
+func CheckSlate(name string) error {
+	if name == "" || name == NoSlate {
+		return nil
+	}
+	_, _, err := LoadSlate(name)
+	return err
+}
+
+// ChannelSlate returns the slate a channel shows while it's offline, empty for none
+func ChannelSlate(channel *model.Channel) string {
+	name := strings.TrimSpace(channel.Slate)
+	if name == "" {
+		name, _ = global.GetConfig("offline_slate")
+		name = strings.TrimSpace(name)
+	}
+	if name == NoSlate {
+		return ""
+	}
+	return name
+}
+
+// SlatePlaylist loops a slate as a live playlist, so that clients keep reloading it and pick up the channel once it's back.
+// Every loop is a discontinuity, the sequence starts when the channel went offline so that the real stream continues forward.
+func SlatePlaylist(key string, name string, slateUrl string) (string, error) {
+	_, info, err := LoadSlate(name)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	session, ok := slateSessions.Load(key)
+	if !ok || now.Sub(session.last) > slateSessionTimeout {
+		session.since = now
+		session.first = 1
+		// a channel that drops out again shortly after it came back continues past what it served meanwhile
+		if h, ok := slateHandovers.LoadAndDelete(key); ok {
+			h.lock.Lock()
+			if now.Sub(h.last) <= slateSessionTimeout {
+				session.first = h.served + 1
+			}
+			h.lock.Unlock()
+		}
+	}
+	seq := session.first + int(now.Sub(session.since).Seconds()/info.Duration)
+	session.last = now
+	session.end = seq + slateWindow - 1
+	slateSessions.Store(key, session)
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&sb, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(info.Duration)))
+	fmt.Fprintf(&sb, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+	// every segment but the first is preceded by a discontinuity, so the first one of the window has number seq
+	fmt.Fprintf(&sb, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", seq)
+	for i := 0; i < slateWindow; i++ {
+		if i > 0 {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&sb, "#EXTINF:%.3f,\n%s&s=%d\n", info.Duration, slateUrl, seq+i)
+	}
+	return sb.String(), nil
+}
+
+// EndSlate is called once a channel plays again, it reports whether its slate was being shown.
+// The playlists served after that go through ContinueAfterSlate.
+func EndSlate(key string) bool {
+	session, ok := slateSessions.LoadAndDelete(key)
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(session.last) > slateSessionTimeout {
+		return false // nobody is watching, there is nothing to continue
+	}
+	slateHandovers.Store(key, &slateHandover{next: session.end + 1, served: session.end, last: now})
+	return true
+}
+
+// ContinueAfterSlate renumbers the media playlist of a channel that came back from its slate: its segments keep their
+// order but are numbered past the last slate segment, and the first of them is marked as a discontinuity.
+// Low latency playlists are left alone since their parts and blocking reloads refer to the source's numbers.
+func ContinueAfterSlate(key string, playlist string) string {
+	h, ok := slateHandovers.Load(key)
+	if !ok {
+		return playlist
+	}
+	if strings.Contains(playlist, "#EXT-X-STREAM-INF") || isLowLatencyPlaylist(playlist) {
+		return playlist
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	if now.Sub(h.last) > slateSessionTimeout {
+		// nobody kept watching, new clients can have the source's numbers
+		slateHandovers.CompareAndDelete(key, h)
+		return playlist
+	}
+	h.last = now
+	return h.renumber(playlist)
+}
+
+func (h *slateHandover) renumber(playlist string) string {
+	lines := strings.Split(playlist, "\n")
+	var (
+		mediaSeq, discSeq uint64
+		segments          int
+	)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			mediaSeq, _ = strconv.ParseUint(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			discSeq, _ = strconv.ParseUint(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"), 10, 64)
+		case line != "" && !strings.HasPrefix(line, "#"):
+			segments++
+		}
+	}
+	if segments == 0 {
+		return playlist
+	}
+	if !h.started || mediaSeq < h.source {
+		// the first playlist since the slate, or the source started over: continue past what was served
+		if h.started {
+			h.next = h.served + 1
+		}
+		h.started = true
+		h.first = mediaSeq
+		h.mediaOffset = h.next - int(mediaSeq)
+		// the leading discontinuity gives the first segment the number next
+		h.discOffset = h.next - int(discSeq)
+	}
+	h.source = mediaSeq
+	h.served = max(h.served, int(mediaSeq)+h.mediaOffset+segments-1)
+	leading := mediaSeq == h.first
+	discOut := int(discSeq) + h.discOffset
+	if leading {
+		discOut--
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", int(mediaSeq)+h.mediaOffset, discOut)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed == "#EXTM3U" ||
+			strings.HasPrefix(trimmed, "#EXT-X-MEDIA-SEQUENCE:") || strings.HasPrefix(trimmed, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			continue
+		case leading && strings.HasPrefix(trimmed, "#EXTINF"):
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+			leading = false
+		}
+		sb.WriteString(trimmed)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
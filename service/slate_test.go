@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func useBuiltinSlate(t *testing.T) {
+	data, err := os.ReadFile("../handler/web/placeholder.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBuiltinSlate(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func playlistSeq(t *testing.T, playlist, tag string) int {
+	for _, line := range strings.Split(playlist, "\n") {
+		if value, ok := strings.CutPrefix(line, tag+":"); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+func livePlaylist(mediaSeq int, segments ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSeq)
+	for _, s := range segments {
+		fmt.Fprintf(&sb, "#EXTINF:4.000,\n%s\n", s)
+	}
+	return sb.String()
+}
+
+func TestSlateHandover(t *testing.T) {
+	useBuiltinSlate(t)
+	const key = "handover"
+	slate, err := SlatePlaylist(key, BuiltinSlate, "slate.ts?name=builtin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastSlate := playlistSeq(t, slate, "#EXT-X-MEDIA-SEQUENCE") + slateWindow - 1
+	if !EndSlate(key) {
+		t.Fatal("EndSlate didn't report the slate")
+	}
+
+	// the source is far behind the slate's numbers, its first segment comes right after the last slate segment
+	first := ContinueAfterSlate(key, livePlaylist(0, "a.ts", "b.ts"))
+	if got := playlistSeq(t, first, "#EXT-X-MEDIA-SEQUENCE"); got != lastSlate+1 {
+		t.Errorf("media sequence after the slate = %d, want %d", got, lastSlate+1)
+	}
+	if !strings.Contains(first, "#EXT-X-DISCONTINUITY\n#EXTINF:4.000,\na.ts") {
+		t.Errorf("the first real segment isn't marked as a discontinuity:\n%s", first)
+	}
+	// the leading discontinuity makes the first segment lastSlate+1, same as the later playlists say
+	if got := playlistSeq(t, first, "#EXT-X-DISCONTINUITY-SEQUENCE"); got != lastSlate {
+		t.Errorf("discontinuity sequence after the slate = %d, want %d", got, lastSlate)
+	}
+
+	next := ContinueAfterSlate(key, livePlaylist(1, "b.ts", "c.ts"))
+	if got := playlistSeq(t, next, "#EXT-X-MEDIA-SEQUENCE"); got != lastSlate+2 {
+		t.Errorf("media sequence of the next playlist = %d, want %d", got, lastSlate+2)
+	}
+	if got := playlistSeq(t, next, "#EXT-X-DISCONTINUITY-SEQUENCE"); got != lastSlate+1 {
+		t.Errorf("discontinuity sequence of the next playlist = %d, want %d", got, lastSlate+1)
+	}
+	if strings.Contains(next, "#EXT-X-DISCONTINUITY\n") {
+		t.Errorf("the discontinuity is repeated after the first segment slid out:\n%s", next)
+	}
+
+	// the source restarts its numbering, the playlist still moves forward
+	restarted := ContinueAfterSlate(key, livePlaylist(0, "x.ts"))
+	if got := playlistSeq(t, restarted, "#EXT-X-MEDIA-SEQUENCE"); got != lastSlate+4 {
+		t.Errorf("media sequence after a restart = %d, want %d", got, lastSlate+4)
+	}
+	if !strings.Contains(restarted, "#EXT-X-DISCONTINUITY\n#EXTINF:4.000,\nx.ts") {
+		t.Errorf("the restart isn't marked as a discontinuity:\n%s", restarted)
+	}
+
+	// going offline again, the slate continues past the real segments
+	slate, err = SlatePlaylist(key, BuiltinSlate, "slate.ts?name=builtin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := playlistSeq(t, slate, "#EXT-X-MEDIA-SEQUENCE"); got != lastSlate+5 {
+		t.Errorf("media sequence of the second slate = %d, want %d", got, lastSlate+5)
+	}
+	EndSlate(key)
+}
+
+func TestContinueWithoutSlate(t *testing.T) {
+	playlist := livePlaylist(7, "a.ts")
+	if got := ContinueAfterSlate("never-offline", playlist); got != playlist {
+		t.Errorf("a channel that never showed its slate was renumbered:\n%s", got)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"sync"
 	"time"
 
 	"github.com/snowie2000/livetv/syncx"
@@ -24,10 +25,17 @@ const (
 
 const MaxRetryCount = 5
 
-var statusCache syncx.Map[any, *StatusInfo]
+var (
+	statusCache syncx.Map[any, *StatusInfo]
+	notices     syncx.Map[string, string]
+	noticeLock  sync.Mutex
+)
 
 func UpdateStatus(url any, status int, msg string) {
 	if c, ok := statusCache.Load(url); ok {
+		if sUrl, ok := url.(string); ok {
+			notifyStatusChange(sUrl, c.Status, status, msg)
+		}
 		c.Msg = msg
 		c.Status = status
 		c.Time = time.Now()
@@ -64,4 +72,31 @@ func GetStatus(url any) *StatusInfo {
 
 func DeleteStatus(url any) {
 	statusCache.Delete(url)
+	if sUrl, ok := url.(string); ok {
+		notices.Delete(sUrl)
+	}
+}
+
+// SetNotice shows a passing condition of a healthy channel, like an ad break, as a warning in its status.
+// It never hides a real problem, and an empty msg puts the channel back to ok if the notice is still what it shows.
+func SetNotice(url string, msg string) {
+	noticeLock.Lock()
+	defer noticeLock.Unlock()
+	prev, _ := notices.Load(url)
+	if msg == prev {
+		return
+	}
+	status := GetStatus(url)
+	if msg == "" {
+		notices.Delete(url)
+		if status.Status == Warning && status.Msg == prev {
+			UpdateStatus(url, Ok, "Live!")
+		}
+		return
+	}
+	if status.Status != Ok && !(status.Status == Warning && status.Msg == prev) {
+		return
+	}
+	notices.Store(url, msg)
+	UpdateStatus(url, Warning, msg)
 }
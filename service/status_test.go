@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/snowie2000/livetv/global"
+)
+
+func TestSetNotice(t *testing.T) {
+	global.ConfigCache.Store("webhook_debounce", "3600") // keep status webhooks from firing
+	const url = "https://www.twitch.tv/notice"
+	t.Cleanup(func() { DeleteStatus(url) })
+
+	UpdateStatus(url, Ok, "Live!")
+	SetNotice(url, "ad break")
+	if s := GetStatus(url); s.Status != Warning || s.Msg != "ad break" {
+		t.Fatalf("status during the ad break: %d %q", s.Status, s.Msg)
+	}
+	SetNotice(url, "ad break")
+	if s := GetStatus(url); s.RetryCount != 1 {
+		t.Errorf("a repeated notice counts as another failure: %d", s.RetryCount)
+	}
+	SetNotice(url, "")
+	if s := GetStatus(url); s.Status != Ok || s.RetryCount != 0 {
+		t.Errorf("status after the ad break: %d %q, %d retries", s.Status, s.Msg, s.RetryCount)
+	}
+
+	// a real problem is never hidden by a notice, nor cleared with it
+	UpdateStatus(url, Warning, "Unhealthy")
+	SetNotice(url, "ad break")
+	SetNotice(url, "")
+	if s := GetStatus(url); s.Status != Warning || s.Msg != "Unhealthy" {
+		t.Errorf("the notice replaced a real warning: %d %q", s.Status, s.Msg)
+	}
+}
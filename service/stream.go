@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/grafov/m3u8"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+	"github.com/snowie2000/livetv/util"
+)
+
+const (
+	// segments behind the live edge a new stream starts with, like players do
+	streamStartSegments = 3
+	// a playing stream survives this many failed playlist reloads in a row, about two minutes with the backoff
+	streamMaxRetries = 8
+	streamMaxBackoff = 30 * time.Second
+)
+
+var errFMP4Stream = errors.New("fMP4 streams can't be played as MPEG-TS")
+
+// the media playlist of a channel, a master playlist is resolved to the variant the channel's quality asks for
+func streamPlaylist(c *gin.Context, channel *model.Channel, parser plugin.Plugin, liveInfo *model.LiveInfo) (string, *m3u8.MediaPlaylist, error) {
+	var (
+		playlistUrl string
+		body        string
+		err         error
+	)
+	if forger, ok := parser.(plugin.Forger); ok {
+		playlistUrl, body, err = forger.ForgeM3U8(c.Request.Context(), liveInfo)
+	} else {
+		body, playlistUrl, err = GetM3U8Content(c, channel, liveInfo.LiveUrl)
+	}
+	if body == "" {
+		if err == nil {
+			err = errors.New("empty playlist")
+		}
+		return "", nil, err
+	}
+	filter := ChannelAdFilter(channel)
+	body = filter.Apply(adFilterKey(channel.ID, playlistUrl), body)
+	p, listType, err := m3u8.DecodeFrom(bytes.NewBufferString(body), false)
+	if err != nil {
+		return "", nil, err
+	}
+	if listType == m3u8.MASTER {
+		master := p.(*m3u8.MasterPlaylist)
+		var streams []*m3u8.Variant
+		for _, v := range master.Variants {
+			if !v.Iframe {
+				streams = append(streams, v)
+			}
+		}
+		if len(streams) == 0 {
+			return "", nil, errors.New("empty master playlist")
+		}
+		quality, _ := plugin.ParseQuality(channel.Quality)
+		selected := streams[quality.Pick(len(streams),
+			func(i int) int { return plugin.ResolutionHeight(streams[i].Resolution) },
+			func(i int) float64 { return float64(streams[i].Bandwidth) })]
+		variantUrl := selected.URI
+		if !global.IsValidURL(variantUrl) {
+			variantUrl = global.CleanUrl(global.MergeUrl(global.GetBaseURL(playlistUrl), variantUrl))
+		}
+		body, playlistUrl, err = GetM3U8Content(c, channel, variantUrl)
+		if body == "" {
+			return "", nil, err
+		}
+		body = filter.Apply(adFilterKey(channel.ID, playlistUrl), body)
+		p, listType, err = m3u8.DecodeFrom(bytes.NewBufferString(body), false)
+		if err != nil {
+			return "", nil, err
+		}
+		if listType != m3u8.MEDIA {
+			return "", nil, errors.New("nested master playlists are not supported")
+		}
+	}
+	return playlistUrl, p.(*m3u8.MediaPlaylist), nil
+}
+
+// fetch a segment the way the ts proxy would, decrypted if it's AES-128 encrypted
+func fetchSegment(ctx context.Context, channel *model.Channel, parser plugin.Plugin, liveInfo *model.LiveInfo, segmentUrl string, key *m3u8.Key, keyUrl string, seqId uint64) ([]byte, error) {
+	client := http.Client{
+		Timeout:   global.HttpClientTimeout * 3,
+		Transport: global.TransportWithProxy(channel.ProxyUrl),
+		Jar:       plugin.ChannelCookieJar(channel),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, segmentUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", global.DefaultUserAgent)
+	if transformer, ok := parser.(plugin.Transformer); ok {
+		transformer.Transform(req, liveInfo)
+	}
+	plugin.ApplyChannelHeaders(req, channel)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer global.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("segment server response: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Method == "" || key.Method == "NONE" {
+		return data, nil
+	}
+	if key.Method != "AES-128" {
+		return nil, errors.New(key.Method + " encryption is not supported")
+	}
+	secret, err := SegmentKey(&client, req, keyUrl)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(segmentIV(key, seqId))
+	if err != nil {
+		return nil, err
+	}
+	return util.DecryptAES128(data, secret, iv)
+}
+
+// StreamTS plays an HLS channel to w as one continuous MPEG-TS stream, until the client goes away or the live ends
+func StreamTS(c *gin.Context, channel *model.Channel, w io.Writer) error {
+	ctx := c.Request.Context()
+	parser, err := plugin.GetPlugin(channel.Parser)
+	if err != nil {
+		return err
+	}
+	cc := util.NewTSContinuity()
+	var (
+		lastUrl   string
+		nextSeq   uint64 // the first segment that hasn't been written
+		started   bool
+		skipped   bool // a segment was lost, the next one doesn't follow what was written
+		streaming bool // the client has got data, errors are retried instead of ending the stream
+		failures  int  // playlist reloads that failed in a row
+	)
+	for ctx.Err() == nil {
+		var (
+			playlistUrl string
+			pl          *m3u8.MediaPlaylist
+		)
+		liveInfo, err := GetLiveM3U8(ctx, channel)
+		if err == nil {
+			playlistUrl, pl, err = streamPlaylist(c, channel, parser, liveInfo)
+		}
+		if err != nil {
+			failures++
+			if !streaming || failures > streamMaxRetries || ctx.Err() != nil {
+				return err
+			}
+			wait := min(time.Second<<(failures-1), streamMaxBackoff)
+			log.Println(channel.URL, "playlist reload failed, retrying in", wait, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		}
+		failures = 0
+		if pl.Map != nil {
+			return errFMP4Stream
+		}
+		segments := pl.Segments[:pl.Count()]
+		// a reparsed url may number its segments differently, start over from its live edge
+		if playlistUrl != lastUrl {
+			if started {
+				skipped = true
+			}
+			started = false
+			lastUrl = playlistUrl
+		}
+		if !started && len(segments) > 0 {
+			first := 0
+			if !pl.Closed && len(segments) > streamStartSegments {
+				first = len(segments) - streamStartSegments
+			}
+			nextSeq = segments[first].SeqId
+			started = true
+		}
+		baseUrl := global.GetBaseURL(playlistUrl)
+		resolve := func(uri string) string {
+			if !global.IsValidURL(uri) {
+				uri = global.CleanUrl(global.MergeUrl(baseUrl, uri))
+			}
+			return uri
+		}
+		wrote := false
+		var key *m3u8.Key
+		for _, seg := range segments {
+			if seg.Key != nil {
+				key = seg.Key
+			}
+			if seg.Map != nil {
+				return errFMP4Stream
+			}
+			if seg.SeqId < nextSeq {
+				continue
+			}
+			if seg.SeqId > nextSeq || seg.Discontinuity || skipped {
+				cc.Discontinuity()
+				skipped = false
+			}
+			nextSeq = seg.SeqId + 1
+			keyUrl := ""
+			if key != nil {
+				keyUrl = resolve(key.URI)
+			}
+			data, err := fetchSegment(ctx, channel, parser, liveInfo, resolve(seg.URI), key, keyUrl, seg.SeqId)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Println(channel.URL, "segment", seg.SeqId, "skipped:", err)
+				skipped = true
+				continue
+			}
+			if _, err := w.Write(cc.Process(data)); err != nil {
+				return nil // the client has gone
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			wrote = true
+			streaming = true
+		}
+		if pl.Closed {
+			return nil
+		}
+		if !wrote {
+			// nothing new yet, check again in half a target duration
+			wait := time.Duration(pl.TargetDuration * float64(time.Second) / 2)
+			if wait < time.Second {
+				wait = time.Second
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}
+	return nil
+}
@@ -37,7 +37,8 @@ func (g *genre) String() string {
 	return strings.Join(channels, "\n")
 }
 
-func TXTGenerate() (string, error) {
+// TXTGenerate lists all channels, profileQuery is appended to every channel link
+func TXTGenerate(profileQuery string) (string, error) {
 	baseUrl, err := global.GetConfig("base_url")
 	if err != nil {
 		log.Println(err)
@@ -56,14 +57,14 @@ func TXTGenerate() (string, error) {
 			category = ch.Category
 		}
 		if g, ok := genres[category]; ok {
-			g.addChannel(ch.Name, fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, ch.Token, ch.ChannelID))
+			g.addChannel(ch.Name, fmt.Sprintf("%s/live.m3u8?token=%s&c=%s%s", baseUrl, ch.Token, ch.ChannelID, profileQuery))
 		} else {
 			g = &genre{
 				name:     category,
 				channels: make(map[string][]string),
 			}
 			genreList = append(genreList, category)
-			g.addChannel(ch.Name, fmt.Sprintf("%s/live.m3u8?token=%s&c=%s", baseUrl, ch.Token, ch.ChannelID))
+			g.addChannel(ch.Name, fmt.Sprintf("%s/live.m3u8?token=%s&c=%s%s", baseUrl, ch.Token, ch.ChannelID, profileQuery))
 			genres[category] = g
 		}
 	}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+const (
+	EventStatus   = "status"
+	EventChildren = "children"
+)
+
+// payload of a webhook notification, also the data passed to body templates
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	ChannelID string    `json:"channel_id"`
+	Channel   string    `json:"channel"`
+	URL       string    `json:"url"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// a state change waiting for the debounce interval to pass
+type pendingNotify struct {
+	timer  *time.Timer
+	from   int
+	to     int
+	msg    string
+	before map[string]string // child url => name
+	after  map[string]string
+}
+
+var (
+	notifyLock    sync.Mutex
+	pendingEvents = make(map[string]*pendingNotify)
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) string {
+		js, _ := json.Marshal(v)
+		return string(js)
+	},
+	"join": strings.Join,
+}
+
+func StatusName(status int) string {
+	switch status {
+	case Ok:
+		return "ok"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	case Expired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+func isNotifiableStatus(status int) bool {
+	return status == Ok || status == Warning || status == Error
+}
+
+func webhookDebounce() time.Duration {
+	sec, _ := global.GetConfig("webhook_debounce")
+	if n, err := strconv.Atoi(sec); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	return time.Minute
+}
+
+// find a channel (or a sub channel) by its url to give the event a readable name
+func channelByURL(url string) (ch model.Channel, found bool) {
+	global.ChannelCache.Range(func(key string, value model.Channel) bool {
+		if value.URL == url {
+			ch = value
+			found = true
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// schedule a notification for key, restart the countdown if one is already pending.
+func debounceNotify(key string, update func(p *pendingNotify), fire func(p *pendingNotify)) {
+	notifyLock.Lock()
+	defer notifyLock.Unlock()
+	p, ok := pendingEvents[key]
+	if ok {
+		p.timer.Stop()
+	} else {
+		p = &pendingNotify{}
+		pendingEvents[key] = p
+	}
+	update(p)
+	p.timer = time.AfterFunc(webhookDebounce(), func() {
+		notifyLock.Lock()
+		if pendingEvents[key] != p {
+			notifyLock.Unlock()
+			return
+		}
+		delete(pendingEvents, key)
+		notifyLock.Unlock()
+		fire(p)
+	})
+}
+
+// called by UpdateStatus whenever the status of a url changes
+func notifyStatusChange(url string, from int, to int, msg string) {
+	if from == to || !isNotifiableStatus(from) || !isNotifiableStatus(to) {
+		return
+	}
+	debounceNotify(EventStatus+":"+url, func(p *pendingNotify) {
+		if p.timer == nil {
+			p.from = from // the status before the first change in this window
+		}
+		p.to = to
+		p.msg = msg
+	}, func(p *pendingNotify) {
+		if p.from == p.to {
+			return // flapped back to where it was
+		}
+		ev := &WebhookEvent{
+			Event:   EventStatus,
+			URL:     url,
+			From:    StatusName(p.from),
+			To:      StatusName(p.to),
+			Message: p.msg,
+		}
+		sendWebhooks(ev)
+	})
+}
+
+func childrenOf(channel *model.Channel, provider plugin.ChannalProvider, info *model.LiveInfo) map[string]string {
+	children := make(map[string]string)
+	for _, ch := range provider.Channels(channel, info) {
+		children[ch.URL] = ch.Name
+	}
+	return children
+}
+
+// compare the sub channels provided by the old and the new liveinfo of a parent channel
+func notifyChildrenChange(channel *model.Channel, oldInfo *model.LiveInfo, newInfo *model.LiveInfo) {
+	if oldInfo == nil || newInfo == nil || oldInfo.ExtraInfo == newInfo.ExtraInfo {
+		return
+	}
+	p, err := plugin.GetPlugin(channel.Parser)
+	if err != nil {
+		return
+	}
+	provider, ok := p.(plugin.ChannalProvider)
+	if !ok {
+		return
+	}
+	before := childrenOf(channel, provider, oldInfo)
+	after := childrenOf(channel, provider, newInfo)
+	debounceNotify(EventChildren+":"+channel.URL, func(p *pendingNotify) {
+		if p.timer == nil {
+			p.before = before
+		}
+		p.after = after
+	}, func(p *pendingNotify) {
+		ev := &WebhookEvent{
+			Event: EventChildren,
+			URL:   channel.URL,
+		}
+		for url, name := range p.after {
+			if _, ok := p.before[url]; !ok {
+				ev.Added = append(ev.Added, name)
+			}
+		}
+		for url, name := range p.before {
+			if _, ok := p.after[url]; !ok {
+				ev.Removed = append(ev.Removed, name)
+			}
+		}
+		if len(ev.Added) == 0 && len(ev.Removed) == 0 {
+			return
+		}
+		sort.Strings(ev.Added)
+		sort.Strings(ev.Removed)
+		ev.Message = fmt.Sprintf("%d added, %d removed", len(ev.Added), len(ev.Removed))
+		sendWebhooks(ev)
+	})
+}
+
+func subscribes(hook *model.Webhook, event string) bool {
+	if strings.TrimSpace(hook.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(hook.Events, ",") {
+		if strings.EqualFold(strings.TrimSpace(e), event) {
+			return true
+		}
+	}
+	return false
+}
+
+func renderWebhookBody(hook *model.Webhook, ev *WebhookEvent) ([]byte, error) {
+	if strings.TrimSpace(hook.Template) == "" {
+		return json.Marshal(ev)
+	}
+	tpl, err := template.New(hook.Name).Funcs(templateFuncs).Parse(hook.Template)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	err = tpl.Execute(&body, ev)
+	return body.Bytes(), err
+}
+
+func postWebhook(hook *model.Webhook, ev *WebhookEvent) error {
+	body, err := renderWebhookBody(hook, ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", global.DefaultUserAgent)
+	for k, v := range global.ParseHeaderLines(hook.Headers) {
+		req.Header[k] = v
+	}
+	client := http.Client{
+		Timeout:   global.HttpClientTimeout,
+		Transport: global.TransportWithProxy(""),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer global.CloseBody(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("Webhook response: HTTP %d", resp.StatusCode))
+	}
+	return nil
+}
+
+func sendWebhooks(ev *WebhookEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ch, ok := channelByURL(ev.URL); ok {
+		ev.ChannelID = ch.ChannelID
+		ev.Channel = ch.Name
+	}
+	hooks, err := GetAllWebhooks()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hook.Enabled || !subscribes(hook, ev.Event) {
+			continue
+		}
+		go func(hook *model.Webhook) {
+			if err := postWebhook(hook, ev); err != nil {
+				log.Println("webhook", hook.Name, "failed:", err)
+			}
+		}(hook)
+	}
+}
+
+// send a fake event to a webhook so that the admin can verify its settings
+func TestWebhook(id int) error {
+	var hook model.Webhook
+	err := global.DB.Where("id = ?", id).First(&hook).Error
+	if err != nil {
+		return err
+	}
+	return postWebhook(&hook, &WebhookEvent{
+		Event:   EventStatus,
+		Channel: "LiveTV",
+		From:    StatusName(Ok),
+		To:      StatusName(Error),
+		Message: "This is a test notification",
+		Time:    time.Now(),
+	})
+}
+
+func GetAllWebhooks() (hooks []*model.Webhook, err error) {
+	err = global.DB.Find(&hooks).Error
+	return
+}
+
+func SaveWebhook(hook *model.Webhook) error {
+	if strings.TrimSpace(hook.Template) != "" {
+		if _, err := template.New(hook.Name).Funcs(templateFuncs).Parse(hook.Template); err != nil {
+			return err
+		}
+	}
+	return global.DB.Save(hook).Error
+}
+
+func DeleteWebhook(id int) error {
+	return global.DB.Delete(model.Webhook{}, "id = ?", id).Error
+}
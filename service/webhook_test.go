@@ -0,0 +1,138 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snowie2000/livetv/global"
+	"github.com/snowie2000/livetv/model"
+	"github.com/snowie2000/livetv/plugin"
+)
+
+// a fresh database with one webhook posting to a test server, the bodies it receives come out of the channel
+func webhookServer(t *testing.T, hook model.Webhook) <-chan string {
+	if err := global.InitDB(filepath.Join(t.TempDir(), "livetv.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { global.DB.Close() })
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "bad token", http.StatusForbidden)
+			body = []byte("the webhook headers were not sent")
+		}
+		bodies <- string(body)
+	}))
+	t.Cleanup(srv.Close)
+	hook.URL = srv.URL
+	hook.Headers = "X-Token: secret"
+	hook.Enabled = true
+	if err := SaveWebhook(&hook); err != nil {
+		t.Fatal(err)
+	}
+	return bodies
+}
+
+func receive(t *testing.T, bodies <-chan string) string {
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was sent")
+		return ""
+	}
+}
+
+func expectNothing(t *testing.T, bodies <-chan string, wait time.Duration) {
+	select {
+	case body := <-bodies:
+		t.Errorf("unexpected webhook: %s", body)
+	case <-time.After(wait):
+	}
+}
+
+func TestWebhookStatusDebounce(t *testing.T) {
+	bodies := webhookServer(t, model.Webhook{Name: "status", Events: "status"})
+	global.ConfigCache.Store("webhook_debounce", "1")
+	const url = "https://example.com/debounce.m3u8"
+	t.Cleanup(func() { DeleteStatus(url) })
+
+	UpdateStatus(url, Ok, "Live!")
+	// a flap within the debounce interval is not worth a notification
+	UpdateStatus(url, Error, "offline")
+	UpdateStatus(url, Ok, "Live!")
+	expectNothing(t, bodies, 1500*time.Millisecond)
+
+	// the notification reports the status before the first change and the last message
+	UpdateStatus(url, Warning, "Unhealthy")
+	UpdateStatus(url, Error, "offline")
+	var ev WebhookEvent
+	if err := json.Unmarshal([]byte(receive(t, bodies)), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != EventStatus || ev.URL != url || ev.From != "ok" || ev.To != "error" || ev.Message != "offline" {
+		t.Errorf("got %+v", ev)
+	}
+}
+
+func TestWebhookChildrenChange(t *testing.T) {
+	bodies := webhookServer(t, model.Webhook{
+		Name:     "children",
+		Events:   "children",
+		Template: `{{.Message}}: +{{join .Added ","}} -{{join .Removed ","}} {{json .URL}}`,
+	})
+	global.ConfigCache.Store("webhook_debounce", "0")
+	channel := &model.Channel{ID: 1, Name: "News", URL: "https://www.youtube.com/@news", Parser: "youtube-streams"}
+	streams := func(ids ...string) *model.LiveInfo {
+		var list []plugin.YoutubeStream
+		for _, id := range ids {
+			list = append(list, plugin.YoutubeStream{VideoID: id, Title: strings.ToUpper(id)})
+		}
+		js, _ := json.Marshal(list)
+		return &model.LiveInfo{ExtraInfo: string(js)}
+	}
+
+	notifyChildrenChange(channel, streams("a", "b"), streams("a", "b"))
+	notifyChildrenChange(channel, nil, streams("a"))
+	expectNothing(t, bodies, 200*time.Millisecond)
+
+	notifyChildrenChange(channel, streams("a", "b"), streams("b", "c", "d"))
+	want := `2 added, 1 removed: +C,D -A "https://www.youtube.com/@news"`
+	if got := receive(t, bodies); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	tests := []struct {
+		events string
+		event  string
+		want   bool
+	}{
+		{"", EventStatus, true},
+		{"status", EventStatus, true},
+		{" Children , status", EventChildren, true},
+		{"children", EventStatus, false},
+	}
+	for _, tt := range tests {
+		if got := subscribes(&model.Webhook{Events: tt.events}, tt.event); got != tt.want {
+			t.Errorf("subscribes(%q, %s) = %v, want %v", tt.events, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestSaveWebhookTemplate(t *testing.T) {
+	if err := SaveWebhook(&model.Webhook{Name: "broken", Template: "{{.Channel"}); err == nil {
+		t.Error("a broken template was saved")
+	}
+	if err := SaveWebhook(&model.Webhook{Name: "unknown", Template: "{{nope .Channel}}"}); err == nil {
+		t.Error("a template with an unknown function was saved")
+	}
+}
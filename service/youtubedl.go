@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -31,23 +32,24 @@ type Dialer interface {
 
 var errNoMatchFound error = errors.New("This channel is not currently live")
 
-const DefaultUserAgent string = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
-
-func GetLiveM3U8(youtubeURL string, proxyUrl string, Parser string) (*model.LiveInfo, error) {
-	liveInfo, ok := global.URLCache.Load(youtubeURL)
-	if ok {
+func GetLiveM3U8(ctx context.Context, channel *model.Channel) (*model.LiveInfo, error) {
+	liveInfo, ok := global.URLCache.Load(channel.URL)
+	if ok && !isExpired(liveInfo) {
 		return liveInfo, nil
 	} else {
-		log.Println("cache miss", youtubeURL)
-		status := GetStatus(youtubeURL)
+		log.Println("cache miss", channel.URL)
+		status := GetStatus(channel.URL)
 		coolDownInterval := time.Second * time.Duration(status.CoolDownMultiplier)
 		if coolDownInterval > time.Minute*2 {
 			coolDownInterval = time.Minute * 2
 		}
 		if time.Now().Sub(status.Time) > coolDownInterval {
-			if liveInfo, err := UpdateURLCacheSingle(&model.Channel{URL: youtubeURL, ProxyUrl: proxyUrl, Parser: Parser}, true); err == nil {
+			if liveInfo, err := UpdateURLCacheContext(ctx, channel, true); err == nil {
 				return liveInfo, nil
 			} else {
+				if ctx.Err() != nil {
+					return nil, err // the client has gone, it's not the parser's fault
+				}
 				if status.CoolDownMultiplier < 1024 {
 					status.CoolDownMultiplier *= 2
 				}
@@ -65,7 +67,7 @@ func isValidM3U(content string) bool {
 }
 
 // returns: content, updated m3u8url (if needed), error
-func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl string, Parser string, flags ...bool) (string, string, error) {
+func GetM3U8Content(c *gin.Context, channel *model.Channel, liveM3U8 string, flags ...bool) (string, string, error) {
 	// parse the optional flags
 	retryFlag := false
 	if len(flags) > 0 {
@@ -74,18 +76,18 @@ func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl
 
 	retry := func(bodyString string, err error) (string, string, error) {
 		newUrl := liveM3U8
-		chStatus := GetStatus(ChannelURL)
+		chStatus := GetStatus(channel.URL)
 		if !retryFlag && chStatus.RetryCount < MaxRetryCount {
 			// this channel was previously running ok, we give it a chance to reparse itself
-			log.Println(ChannelURL, "is unhealthy, doing a reparse...")
-			if li, err := UpdateURLCacheSingle(&model.Channel{URL: ChannelURL, ProxyUrl: ProxyUrl, Parser: Parser}, false); err == nil {
-				UpdateStatus(ChannelURL, Warning, "Unhealthy")
-				bodyString, newUrl, err = GetM3U8Content(c, ChannelURL, li.LiveUrl, ProxyUrl, Parser, true)
+			log.Println(channel.URL, "is unhealthy, doing a reparse...")
+			if li, err := UpdateURLCacheContext(c.Request.Context(), channel, false); err == nil {
+				UpdateStatus(channel.URL, Warning, "Unhealthy")
+				bodyString, newUrl, err = GetM3U8Content(c, channel, li.LiveUrl, true)
 				if err == nil {
-					log.Println(ChannelURL, "is back online now")
-					UpdateStatus(ChannelURL, Ok, "Live!") // revert our temporary warning status to ok
+					log.Println(channel.URL, "is back online now")
+					UpdateStatus(channel.URL, Ok, "Live!") // revert our temporary warning status to ok
 				} else {
-					log.Println(ChannelURL, "is still unhealthy, giving up, currently points to", liveM3U8)
+					log.Println(channel.URL, "is still unhealthy, giving up, currently points to", liveM3U8)
 				}
 				// if error still persists after a reparse, keep our warning status so that we won't endlessly reparse the same feed
 			}
@@ -93,34 +95,36 @@ func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl
 		return bodyString, newUrl, err
 	}
 
-	li, _ := global.URLCache.Load(ChannelURL)
+	li, _ := global.URLCache.Load(channel.URL)
 
 	var dialer Dialer
 	dialer = &net.Dialer{
 		Timeout: global.HttpClientTimeout,
 	}
-	if ProxyUrl != "" {
-		if u, err := url.Parse(ProxyUrl); err == nil {
+	if channel.ProxyUrl != "" {
+		if u, err := url.Parse(channel.ProxyUrl); err == nil {
 			if d, err := proxy.FromURL(u, dialer); err == nil {
 				dialer = d
 			}
 		}
 	}
 	client := http.Client{
-		Timeout:   global.HttpClientTimeout,
 		Transport: global.TransportWithProxy(""),
-		Jar:       global.CookieJar,
+		Jar:       plugin.ChannelCookieJar(channel),
 	}
-	req, err := http.NewRequest(http.MethodGet, liveM3U8, nil)
+	// a playlist reload is a plain request, the parse timeout of the channel is for its parser
+	ctx, cancel := context.WithTimeout(c.Request.Context(), global.HttpClientTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, liveM3U8, nil)
 	if err != nil {
 		log.Println(err)
 		return "", liveM3U8, err
 	}
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("User-Agent", global.DefaultUserAgent)
 	queries := c.Request.URL.Query()
 	reqQuery := req.URL.Query()
 	for key, values := range queries {
-		if strings.HasPrefix(key, "header") || slices.Contains([]string{"k", "c", "token"}, key) {
+		if strings.HasPrefix(key, "header") || slices.Contains([]string{"k", "c", "token"}, key) || slices.Contains(profileParams, key) {
 			continue
 		}
 		for _, value := range values {
@@ -130,13 +134,15 @@ func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl
 	req.URL.RawQuery = reqQuery.Encode()
 
 	// allow plugins to decorate the m3u8 url
-	if p, err := plugin.GetPlugin(Parser); err == nil {
+	if p, err := plugin.GetPlugin(channel.Parser); err == nil {
 		if transformer, ok := p.(plugin.Transformer); ok {
 			if li != nil {
 				transformer.Transform(req, li)
 			}
 		}
 	}
+	// the channel settings come last, they are what the admin asked for
+	plugin.ApplyChannelHeaders(req, channel)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -166,19 +172,24 @@ func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl
 	if isValid {
 		// do custom health checks
 		// retry on custom health check error
-		if p, err := plugin.GetPlugin(Parser); err == nil {
+		if p, err := plugin.GetPlugin(channel.Parser); err == nil {
 			if checker, ok := p.(plugin.HealthCheck); ok {
 				healthErr := checker.Check(bodyString, li)
-				if healthErr != nil {
+				var warning plugin.HealthWarning
+				if errors.As(healthErr, &warning) {
+					SetNotice(channel.URL, string(warning))
+				} else if healthErr != nil {
 					return retry(bodyString, healthErr)
+				} else {
+					SetNotice(channel.URL, "")
 				}
 			}
 		}
 	} else {
-		UpdateStatus(ChannelURL, Warning, "Url is not a live stream")
-		duration, err := GetVideoDuration(ChannelURL)
+		UpdateStatus(channel.URL, Warning, "Url is not a live stream")
+		duration, err := GetVideoDuration(channel.URL)
 		if err == nil && duration > 0 {
-			log.Println(ChannelURL, "duration is", duration)
+			log.Println(channel.URL, "duration is", duration)
 			bodyString = fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%.0f\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:%.4f, video\n%s\n#EXT-X-ENDLIST", duration, duration, liveM3U8)
 		} else {
 			log.Println("failed to get duration", err.Error())
@@ -188,15 +199,25 @@ func GetM3U8Content(c *gin.Context, ChannelURL string, liveM3U8 string, ProxyUrl
 	return bodyString, liveM3U8, nil
 }
 
-func RealLiveM3U8(liveUrl string, proxyUrl string, Parser string) (*model.LiveInfo, error) {
+func RealLiveM3U8(ctx context.Context, liveUrl string, proxyUrl string, Parser string) (*model.LiveInfo, error) {
 	if Parser == "" {
 		Parser = "youtube" // backward compatible with old database, use youtube parser by default
 	}
 	if p, err := plugin.GetPlugin(Parser); err == nil {
+		previousExtraInfo := ""
 		if liveInfo, ok := global.URLCache.Load(liveUrl); ok {
-			return p.Parse(liveUrl, proxyUrl, liveInfo.ExtraInfo)
+			previousExtraInfo = liveInfo.ExtraInfo
+		}
+		liveInfo, err := plugin.ParseContext(ctx, p, liveUrl, proxyUrl, previousExtraInfo)
+		if err == nil && liveInfo != nil && liveInfo.ExpiresAt.IsZero() {
+			liveInfo.ExpiresAt = plugin.GetExpiry(Parser, liveInfo)
+		}
+		if err == nil && liveInfo != nil && isExpired(liveInfo) {
+			// a url that is dead on arrival would be reparsed on every request, its expiry can't be right
+			log.Println(liveUrl, "resolved to an url expiring at", liveInfo.ExpiresAt.Format(time.DateTime), "ignoring the expiry")
+			liveInfo.ExpiresAt = time.Time{}
 		}
-		return p.Parse(liveUrl, proxyUrl, "")
+		return liveInfo, err
 	} else {
 		return nil, err
 	}
@@ -1,12 +1,18 @@
 package syncx
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type Map[K comparable, V any] struct {
 	m sync.Map
 }
 
 func (m *Map[K, V]) Delete(key K) { m.m.Delete(key) }
+func (m *Map[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	return m.m.CompareAndDelete(key, old)
+}
 func (m *Map[K, V]) Load(key K) (value V, ok bool) {
 	v, ok := m.m.Load(key)
 	if !ok {
@@ -38,3 +44,66 @@ func (m *Map[K, V]) Clear() {
 		return true
 	})
 }
+
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// Group coalesces concurrent calls sharing the same key into one execution
+type Group[K comparable, V any] struct {
+	lock  sync.Mutex
+	calls map[K]*call[V]
+}
+
+// join the running call of key, or start one if there's none
+func (g *Group[K, V]) join(key K) (c *call[V], leader bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c = &call[V]{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+func (g *Group[K, V]) run(key K, c *call[V], fn func() (V, error)) {
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+}
+
+// Do runs fn once for all concurrent callers of key, leader reports whether fn was run by this caller
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (val V, err error, leader bool) {
+	c, leader := g.join(key)
+	if leader {
+		g.run(key, c, fn)
+	} else {
+		<-c.done
+	}
+	return c.val, c.err, leader
+}
+
+// DoContext is Do with fn run apart from its callers, so that it goes on when they stop waiting.
+// Each caller waits until fn returns or its own ctx is done, leader reports whether this caller started fn.
+func (g *Group[K, V]) DoContext(ctx context.Context, key K, fn func() (V, error)) (val V, err error, leader bool) {
+	c, leader := g.join(key)
+	if leader {
+		go g.run(key, c, fn)
+	}
+	select {
+	case <-c.done:
+		return c.val, c.err, leader
+	case <-ctx.Done():
+		return val, ctx.Err(), leader
+	}
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+// DecryptAES128 decrypts a segment encrypted with the AES-128 method of HLS: CBC with PKCS7 padding
+func DecryptAES128(data []byte, key []byte, iv []byte) ([]byte, error) {
+	if len(key) != aes.BlockSize || len(iv) != aes.BlockSize {
+		return nil, errors.New("key and iv must be 16 bytes")
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("data is not a multiple of the block size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plain) {
+		return nil, errors.New("invalid padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// encrypt like an HLS packager: AES-128 CBC with PKCS7 padding
+func encryptAES128(t *testing.T, plain []byte, key []byte, iv []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestDecryptAES128(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	for _, size := range []int{0, 1, 15, 16, 188 * 7} {
+		plain := bytes.Repeat([]byte{0x47}, size)
+		got, err := DecryptAES128(encryptAES128(t, plain, key, iv), key, iv)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: decrypted data differs", size)
+		}
+	}
+}
+
+func TestDecryptAES128Errors(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, 16)
+	if _, err := DecryptAES128(make([]byte, 32), key[:8], iv); err == nil {
+		t.Error("a short key should fail")
+	}
+	if _, err := DecryptAES128(make([]byte, 30), key, iv); err == nil {
+		t.Error("data that isn't a multiple of the block size should fail")
+	}
+	if _, err := DecryptAES128(nil, key, iv); err == nil {
+		t.Error("empty data should fail")
+	}
+	// a corrupted last block leaves garbage where the padding should be
+	data := encryptAES128(t, []byte("segment"), key, iv)
+	data[len(data)-1] ^= 0xff
+	if _, err := DecryptAES128(data, key, iv); err == nil {
+		t.Error("bad padding should fail")
+	}
+}
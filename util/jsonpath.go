@@ -0,0 +1,77 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// split a jsonpath like $.data.list[0]['name'] into keys and indexes
+func splitJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	var parts []string
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, errors.New("empty key in jsonpath")
+			}
+			parts = append(parts, path[:end])
+			path = path[end:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, errors.New("unclosed bracket in jsonpath")
+			}
+			key := strings.Trim(path[1:end], `'"`)
+			parts = append(parts, key)
+			path = path[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in jsonpath", path[0])
+		}
+	}
+	return parts, nil
+}
+
+// JSONPath evaluates a simple jsonpath ($.a.b[0]['c']) against a json document and returns the value as string
+func JSONPath(document string, path string) (string, error) {
+	parts, err := splitJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	var node any
+	if err := json.Unmarshal([]byte(document), &node); err != nil {
+		return "", err
+	}
+	for _, part := range parts {
+		switch v := node.(type) {
+		case map[string]any:
+			node = v[part]
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("index %s out of range", part)
+			}
+			node = v[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+	switch v := node.(type) {
+	case nil:
+		return "", fmt.Errorf("%s not found", path)
+	case string:
+		return v, nil
+	default:
+		js, _ := json.Marshal(v)
+		return string(js), nil
+	}
+}
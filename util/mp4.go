@@ -0,0 +1,464 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+// a track of a progressive mp4 file with its sample table expanded
+type mp4Track struct {
+	kind      string // vide or soun
+	timescale uint32
+	config    []byte // avcC or AudioSpecificConfig
+	nalLength int    // length size of h264 nalus
+	sizes     []uint32
+	chunks    []uint64
+	stsc      [][3]uint32
+	stts      [][2]uint32
+	ctts      [][2]int32
+	keyframes map[uint32]bool // nil if every sample is a keyframe
+}
+
+type mp4Sample struct {
+	offset   uint64
+	size     uint32
+	dts      time.Duration
+	cts      time.Duration
+	keyframe bool
+	track    *mp4Track
+}
+
+// iterate the boxes of b, fn gets the type and the payload of every box
+func mp4Boxes(b []byte, fn func(typ string, payload []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return errors.New("mp4: truncated box")
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return errors.New("mp4: truncated " + typ + " box")
+		}
+		if err := fn(typ, b[header:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// the length of an mpeg-4 descriptor and the bytes it takes
+func mp4DescriptorLength(b []byte) (int, int) {
+	length := 0
+	for i := 0; i < 4 && i < len(b); i++ {
+		length = length<<7 | int(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return length, i + 1
+		}
+	}
+	return 0, len(b) + 1
+}
+
+// the AudioSpecificConfig inside an esds box
+func esdsAudioConfig(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errors.New("mp4: truncated esds")
+	}
+	b = b[4:]
+	for len(b) > 2 {
+		tag := b[0]
+		length, n := mp4DescriptorLength(b[1:])
+		if 1+n > len(b) {
+			break
+		}
+		body := b[1+n:]
+		switch tag {
+		case 0x03: // ES_Descriptor
+			if len(body) < 3 {
+				return nil, errors.New("mp4: truncated esds")
+			}
+			flags := body[2]
+			skip := 3
+			if flags&0x80 != 0 {
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(body) > skip {
+				skip += 1 + int(body[skip])
+			}
+			if flags&0x20 != 0 {
+				skip += 2
+			}
+			if skip > len(body) {
+				return nil, errors.New("mp4: truncated esds")
+			}
+			b = body[skip:]
+		case 0x04: // DecoderConfigDescriptor
+			if len(body) < 13 {
+				return nil, errors.New("mp4: truncated esds")
+			}
+			if body[0] != 0x40 {
+				return nil, errors.New("mp4: only aac audio is supported")
+			}
+			b = body[13:]
+		case 0x05: // DecoderSpecificInfo
+			if length > len(body) {
+				return nil, errors.New("mp4: truncated esds")
+			}
+			return body[:length], nil
+		default:
+			if length > len(body) {
+				return nil, errors.New("mp4: truncated esds")
+			}
+			b = body[length:]
+		}
+	}
+	return nil, errors.New("mp4: no aac config found")
+}
+
+func (t *mp4Track) parseSampleEntry(b []byte) error {
+	if len(b) < 16 {
+		return errors.New("mp4: truncated stsd")
+	}
+	entry := b[8:]
+	return mp4Boxes(entry, func(typ string, payload []byte) error {
+		switch typ {
+		case "avc1", "avc3":
+			if len(payload) < 78 {
+				return errors.New("mp4: truncated avc1")
+			}
+			return mp4Boxes(payload[78:], func(typ string, payload []byte) error {
+				if typ == "avcC" && len(payload) > 5 {
+					t.config = payload
+					t.nalLength = int(payload[4]&0x3) + 1
+				}
+				return nil
+			})
+		case "mp4a":
+			if len(payload) < 28 {
+				return errors.New("mp4: truncated mp4a")
+			}
+			skip := 28
+			switch binary.BigEndian.Uint16(payload[8:]) { // quicktime sound description versions
+			case 1:
+				skip += 16
+			case 2:
+				skip += 36
+			}
+			if skip > len(payload) {
+				return errors.New("mp4: truncated mp4a")
+			}
+			var findEsds func(typ string, payload []byte) error
+			findEsds = func(typ string, payload []byte) error {
+				switch typ {
+				case "wave": // quicktime keeps the esds in a wave atom
+					return mp4Boxes(payload, findEsds)
+				case "esds":
+					config, err := esdsAudioConfig(payload)
+					if err != nil {
+						return err
+					}
+					t.config = config
+				}
+				return nil
+			}
+			return mp4Boxes(payload[skip:], findEsds)
+		default:
+			return errors.New("mp4: unsupported codec " + typ + ", only h264 and aac are supported")
+		}
+	})
+}
+
+// the entry count of a full box table and its entries
+func mp4Table(b []byte, entrySize int) ([]byte, int, error) {
+	if len(b) < 8 {
+		return nil, 0, errors.New("mp4: truncated table")
+	}
+	count := int(binary.BigEndian.Uint32(b[4:]))
+	if count < 0 || len(b)-8 < count*entrySize {
+		return nil, 0, errors.New("mp4: truncated table")
+	}
+	return b[8:], count, nil
+}
+
+func (t *mp4Track) parseSampleTable(typ string, b []byte) error {
+	switch typ {
+	case "stsd":
+		if len(b) < 8 {
+			return errors.New("mp4: truncated stsd")
+		}
+		return t.parseSampleEntry(b)
+	case "stsz":
+		if len(b) < 12 {
+			return errors.New("mp4: truncated stsz")
+		}
+		size := binary.BigEndian.Uint32(b[4:])
+		count := int(binary.BigEndian.Uint32(b[8:]))
+		if size == 0 && len(b)-12 < count*4 {
+			return errors.New("mp4: truncated stsz")
+		}
+		t.sizes = make([]uint32, count)
+		for i := range t.sizes {
+			if size != 0 {
+				t.sizes[i] = size
+			} else {
+				t.sizes[i] = binary.BigEndian.Uint32(b[12+i*4:])
+			}
+		}
+	case "stco", "co64":
+		entrySize := 4
+		if typ == "co64" {
+			entrySize = 8
+		}
+		entries, count, err := mp4Table(b, entrySize)
+		if err != nil {
+			return err
+		}
+		t.chunks = make([]uint64, count)
+		for i := range t.chunks {
+			if entrySize == 4 {
+				t.chunks[i] = uint64(binary.BigEndian.Uint32(entries[i*4:]))
+			} else {
+				t.chunks[i] = binary.BigEndian.Uint64(entries[i*8:])
+			}
+		}
+	case "stsc":
+		entries, count, err := mp4Table(b, 12)
+		if err != nil {
+			return err
+		}
+		t.stsc = make([][3]uint32, count)
+		for i := range t.stsc {
+			for j := 0; j < 3; j++ {
+				t.stsc[i][j] = binary.BigEndian.Uint32(entries[i*12+j*4:])
+			}
+		}
+	case "stts":
+		entries, count, err := mp4Table(b, 8)
+		if err != nil {
+			return err
+		}
+		t.stts = make([][2]uint32, count)
+		for i := range t.stts {
+			t.stts[i] = [2]uint32{binary.BigEndian.Uint32(entries[i*8:]), binary.BigEndian.Uint32(entries[i*8+4:])}
+		}
+	case "ctts":
+		entries, count, err := mp4Table(b, 8)
+		if err != nil {
+			return err
+		}
+		t.ctts = make([][2]int32, count)
+		for i := range t.ctts {
+			t.ctts[i] = [2]int32{int32(binary.BigEndian.Uint32(entries[i*8:])), int32(binary.BigEndian.Uint32(entries[i*8+4:]))}
+		}
+	case "stss":
+		entries, count, err := mp4Table(b, 4)
+		if err != nil {
+			return err
+		}
+		t.keyframes = make(map[uint32]bool, count)
+		for i := 0; i < count; i++ {
+			t.keyframes[binary.BigEndian.Uint32(entries[i*4:])] = true
+		}
+	}
+	return nil
+}
+
+func parseMP4Track(trak []byte) (*mp4Track, error) {
+	t := &mp4Track{}
+	var walk func(typ string, payload []byte) error
+	walk = func(typ string, payload []byte) error {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			return mp4Boxes(payload, walk)
+		case "mdhd":
+			if len(payload) < 24 {
+				return errors.New("mp4: truncated mdhd")
+			}
+			if payload[0] == 1 {
+				if len(payload) < 32 {
+					return errors.New("mp4: truncated mdhd")
+				}
+				t.timescale = binary.BigEndian.Uint32(payload[20:])
+			} else {
+				t.timescale = binary.BigEndian.Uint32(payload[12:])
+			}
+		case "hdlr":
+			if t.kind != "" {
+				return nil // quicktime has a data handler in minf as well
+			}
+			if len(payload) < 12 {
+				return errors.New("mp4: truncated hdlr")
+			}
+			t.kind = string(payload[8:12])
+			if t.kind != "vide" && t.kind != "soun" {
+				return errSkipTrack
+			}
+		default:
+			return t.parseSampleTable(typ, payload)
+		}
+		return nil
+	}
+	if err := mp4Boxes(trak, walk); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+var errSkipTrack = errors.New("mp4: not a media track")
+
+// expand the sample table of a track into samples
+func (t *mp4Track) samples() ([]mp4Sample, error) {
+	if t.timescale == 0 || t.config == nil {
+		return nil, errors.New("mp4: incomplete " + t.kind + " track")
+	}
+	samples := make([]mp4Sample, 0, len(t.sizes))
+	toDuration := func(v int64) time.Duration {
+		return time.Duration(v) * time.Second / time.Duration(t.timescale)
+	}
+	// sample offsets from the chunk layout
+	sample := 0
+	for i, offset := range t.chunks {
+		perChunk := uint32(0)
+		for _, e := range t.stsc {
+			if uint64(e[0]) <= uint64(i+1) {
+				perChunk = e[1]
+			}
+		}
+		for j := uint32(0); j < perChunk && sample < len(t.sizes); j++ {
+			samples = append(samples, mp4Sample{offset: offset, size: t.sizes[sample], track: t})
+			offset += uint64(t.sizes[sample])
+			sample++
+		}
+	}
+	if len(samples) != len(t.sizes) {
+		return nil, errors.New("mp4: inconsistent sample table")
+	}
+	// decoding times
+	i := 0
+	dts := int64(0)
+	for _, e := range t.stts {
+		for n := uint32(0); n < e[0] && i < len(samples); n++ {
+			samples[i].dts = toDuration(dts)
+			dts += int64(e[1])
+			i++
+		}
+	}
+	// composition offsets
+	i = 0
+	for _, e := range t.ctts {
+		for n := int32(0); n < e[0] && i < len(samples); n++ {
+			samples[i].cts = toDuration(int64(e[1]))
+			i++
+		}
+	}
+	for i := range samples {
+		samples[i].keyframe = t.keyframes == nil || t.keyframes[uint32(i+1)]
+	}
+	return samples, nil
+}
+
+// DemuxMP4 reads the h264 and aac samples of a progressive (not fragmented) mp4 file in decoding order
+func DemuxMP4(data []byte) ([]av.Packet, error) {
+	var tracks []*mp4Track
+	fragmented := false
+	err := mp4Boxes(data, func(typ string, payload []byte) error {
+		switch typ {
+		case "moov":
+			return mp4Boxes(payload, func(typ string, payload []byte) error {
+				switch typ {
+				case "trak":
+					t, err := parseMP4Track(payload)
+					if err == errSkipTrack {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+					tracks = append(tracks, t)
+				case "mvex":
+					fragmented = true
+				}
+				return nil
+			})
+		case "moof":
+			fragmented = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fragmented {
+		return nil, errors.New("fragmented mp4 is not supported")
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("mp4: no audio or video track")
+	}
+	var packets []av.Packet
+	var samples []mp4Sample
+	for _, t := range tracks {
+		s, err := t.samples()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s...)
+		if t.kind == "vide" {
+			packets = append(packets, av.Packet{Type: av.H264DecoderConfig, Data: t.config})
+		} else {
+			packets = append(packets, av.Packet{Type: av.AACDecoderConfig, Data: t.config})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].dts < samples[j].dts })
+	for _, s := range samples {
+		if s.offset+uint64(s.size) > uint64(len(data)) {
+			return nil, errors.New("mp4: sample out of range")
+		}
+		payload := data[s.offset : s.offset+uint64(s.size)]
+		if s.track.kind == "vide" {
+			packets = append(packets, av.Packet{
+				Type:       av.H264,
+				Data:       avccWithLength4(payload, s.track.nalLength),
+				Time:       s.dts,
+				CTime:      s.cts,
+				IsKeyFrame: s.keyframe,
+			})
+		} else {
+			packets = append(packets, av.Packet{Type: av.AAC, Data: payload, Time: s.dts})
+		}
+	}
+	return packets, nil
+}
+
+// rewrite avcc nalus with a length size other than 4 to 4 byte lengths
+func avccWithLength4(b []byte, lengthSize int) []byte {
+	if lengthSize == 4 {
+		return b
+	}
+	var out []byte
+	for len(b) >= lengthSize {
+		n := 0
+		for i := 0; i < lengthSize; i++ {
+			n = n<<8 | int(b[i])
+		}
+		b = b[lengthSize:]
+		if n > len(b) {
+			break
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(n))
+		out = append(out, b[:n]...)
+		b = b[n:]
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+func mp4Box(typ string, payloads ...[]byte) []byte {
+	body := bytes.Join(payloads, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func be32(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// a full box: version and flags, then its fields
+func mp4FullBox(typ string, payloads ...[]byte) []byte {
+	return mp4Box(typ, append([][]byte{be32(0)}, payloads...)...)
+}
+
+func mp4Trak(handler string, timescale uint32, sampleEntry []byte, tables ...[]byte) []byte {
+	stbl := mp4Box("stbl", append([][]byte{mp4FullBox("stsd", be32(1), sampleEntry)}, tables...)...)
+	return mp4Box("trak", mp4Box("mdia",
+		mp4FullBox("mdhd", be32(0, 0, timescale, 0), []byte{0, 0, 0, 0}),
+		mp4FullBox("hdlr", be32(0), []byte(handler), make([]byte, 13)),
+		mp4Box("minf", stbl),
+	))
+}
+
+// h264 with 2 byte nalu lengths
+func avc1Entry() []byte {
+	return mp4Box("avc1", make([]byte, 78), mp4Box("avcC", []byte{1, 0x64, 0, 0x1f, 0xfd, 0xe0, 0}))
+}
+
+func mp4aEntry(objectType byte) []byte {
+	decoderConfig := append([]byte{0x04, 17, objectType}, make([]byte, 12)...)
+	decoderConfig = append(decoderConfig, 0x05, 2, 0x11, 0x90)
+	esDescriptor := append([]byte{0x03, byte(3 + len(decoderConfig)), 0, 1, 0}, decoderConfig...)
+	return mp4Box("mp4a", make([]byte, 28), mp4FullBox("esds", esDescriptor))
+}
+
+var (
+	mp4VideoSamples = [][]byte{{0, 2, 0x65, 0xaa}, {0, 2, 0x41, 0xbb}, {0, 2, 0x41, 0xcc}}
+	mp4AudioSamples = [][]byte{{0x21, 0x01, 0x02}, {0x21, 0x03, 0x04}}
+)
+
+// a progressive mp4 with 3 h264 frames at 25fps, the first one a keyframe, and 2 aac frames at 48kHz
+func testMP4(audioEntry []byte) []byte {
+	ftyp := mp4Box("ftyp", []byte("isom"), be32(0x200), []byte("isomiso2"))
+	moov := func(mdat uint32) []byte {
+		video := mp4Trak("vide", 12800, avc1Entry(),
+			mp4FullBox("stts", be32(1, 3, 512)),
+			mp4FullBox("ctts", be32(1, 3, 1024)),
+			mp4FullBox("stss", be32(1, 1)),
+			mp4FullBox("stsc", be32(1, 1, 3, 1)),
+			mp4FullBox("stsz", be32(0, 3, 4, 4, 4)),
+			mp4FullBox("stco", be32(1, mdat)),
+		)
+		audio := mp4Trak("soun", 48000, audioEntry,
+			mp4FullBox("stts", be32(1, 2, 1024)),
+			mp4FullBox("stsc", be32(1, 1, 1, 1)),
+			mp4FullBox("stsz", be32(3, 2)),
+			mp4FullBox("stco", be32(2, mdat+12, mdat+15)),
+		)
+		return mp4Box("moov", video, audio)
+	}
+	mdat := uint32(len(ftyp) + len(moov(0)) + 8)
+	payload := append(bytes.Join(mp4VideoSamples, nil), bytes.Join(mp4AudioSamples, nil)...)
+	return bytes.Join([][]byte{ftyp, moov(mdat), mp4Box("mdat", payload)}, nil)
+}
+
+func TestDemuxMP4(t *testing.T) {
+	packets, err := DemuxMP4(testMP4(mp4aEntry(0x40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 7 {
+		t.Fatalf("got %d packets, want 7", len(packets))
+	}
+	if packets[0].Type != av.H264DecoderConfig || packets[0].Data[4] != 0xfd {
+		t.Errorf("first packet isn't the avcC: %+v", packets[0])
+	}
+	if packets[1].Type != av.AACDecoderConfig || !bytes.Equal(packets[1].Data, []byte{0x11, 0x90}) {
+		t.Errorf("second packet isn't the AudioSpecificConfig: %+v", packets[1])
+	}
+	frame := 40 * time.Millisecond
+	audioFrame := time.Duration(1024) * time.Second / 48000
+	want := []struct {
+		typ      int
+		data     []byte
+		time     time.Duration
+		keyframe bool
+	}{
+		{av.H264, []byte{0, 0, 0, 2, 0x65, 0xaa}, 0, true},
+		{av.AAC, mp4AudioSamples[0], 0, false},
+		{av.AAC, mp4AudioSamples[1], audioFrame, false},
+		{av.H264, []byte{0, 0, 0, 2, 0x41, 0xbb}, frame, false},
+		{av.H264, []byte{0, 0, 0, 2, 0x41, 0xcc}, 2 * frame, false},
+	}
+	for i, w := range want {
+		p := packets[i+2]
+		if p.Type != w.typ || !bytes.Equal(p.Data, w.data) || p.Time != w.time || p.IsKeyFrame != w.keyframe {
+			t.Errorf("packet %d = %v %x at %v key %v, want %v %x at %v key %v", i+2,
+				p.Type, p.Data, p.Time, p.IsKeyFrame, w.typ, w.data, w.time, w.keyframe)
+		}
+		if p.Type == av.H264 && p.CTime != 2*frame {
+			t.Errorf("packet %d has composition offset %v, want %v", i+2, p.CTime, 2*frame)
+		}
+	}
+}
+
+func TestDemuxMP4Errors(t *testing.T) {
+	valid := testMP4(mp4aEntry(0x40))
+	// the last audio frame is cut off, with the mdat box shortened to match so that only the sample table points past it
+	outOfRange := append([]byte{}, valid[:len(valid)-1]...)
+	binary.BigEndian.PutUint32(outOfRange[len(valid)-8-18:], 8+17)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated", valid[:len(valid)-1], "truncated mdat"},
+		{"fragmented", append(append([]byte{}, valid...), mp4Box("moof")...), "fragmented"},
+		{"no tracks", mp4Box("moov", mp4Box("trak", mp4Box("mdia", mp4FullBox("hdlr", be32(0), []byte("text"))))), "no audio or video"},
+		{"mp3", testMP4(mp4aEntry(0x6b)), "only aac"},
+		{"hevc", bytes.Replace(valid, []byte("avc1"), []byte("hvc1"), 1), "unsupported codec hvc1"},
+		{"sample out of range", outOfRange, "out of range"},
+	}
+	for _, tt := range tests {
+		if _, err := DemuxMP4(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAvccWithLength4(t *testing.T) {
+	tests := []struct {
+		in         []byte
+		lengthSize int
+		want       []byte
+	}{
+		{[]byte{0, 0, 0, 1, 0x65}, 4, []byte{0, 0, 0, 1, 0x65}},
+		{[]byte{0, 1, 0x65, 0, 2, 0x41, 0x9a}, 2, []byte{0, 0, 0, 1, 0x65, 0, 0, 0, 2, 0x41, 0x9a}},
+		{[]byte{1, 0x65, 1, 0x41}, 1, []byte{0, 0, 0, 1, 0x65, 0, 0, 0, 1, 0x41}},
+		// a nalu longer than what's left is dropped
+		{[]byte{0, 1, 0x65, 0, 9, 0x41}, 2, []byte{0, 0, 0, 1, 0x65}},
+	}
+	for _, tt := range tests {
+		if got := avccWithLength4(tt.in, tt.lengthSize); !bytes.Equal(got, tt.want) {
+			t.Errorf("avccWithLength4(%x, %d) = %x, want %x", tt.in, tt.lengthSize, got, tt.want)
+		}
+	}
+}
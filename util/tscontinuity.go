@@ -0,0 +1,72 @@
+package util
+
+const tsPacketSize = 188
+
+// TSContinuity rewrites MPEG-TS packets of consecutive segments so that they play as one stream.
+// Continuity counters are renumbered per pid, and after a discontinuity the next adaptation field of every pid
+// gets its discontinuity indicator set so that decoders reset their clocks instead of dropping packets.
+type TSContinuity struct {
+	counters map[uint16]byte
+	pending  map[uint16]bool // pids waiting for a discontinuity indicator
+	all      bool            // a discontinuity is pending for pids we haven't seen yet as well
+}
+
+func NewTSContinuity() *TSContinuity {
+	return &TSContinuity{
+		counters: make(map[uint16]byte),
+		pending:  make(map[uint16]bool),
+	}
+}
+
+// Discontinuity marks the next segment as not following the previous one
+func (t *TSContinuity) Discontinuity() {
+	t.all = true
+	for pid := range t.counters {
+		t.pending[pid] = true
+	}
+}
+
+// Process rewrites the packets of a segment in place, bytes before the first sync byte and a trailing partial packet are dropped
+func (t *TSContinuity) Process(data []byte) []byte {
+	start := 0
+	for start < len(data) && data[start] != 0x47 {
+		start++
+	}
+	data = data[start:]
+	data = data[:len(data)-len(data)%tsPacketSize]
+	seen := make(map[uint16]bool)
+	for off := 0; off < len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			continue
+		}
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		if pid == 0x1fff { // null packets
+			continue
+		}
+		if t.all && !seen[pid] {
+			if _, known := t.counters[pid]; !known {
+				t.pending[pid] = true
+			}
+		}
+		seen[pid] = true
+		afc := (pkt[3] >> 4) & 0x3
+		if t.pending[pid] && afc&0x2 != 0 && pkt[4] > 0 {
+			pkt[5] |= 0x80 // discontinuity_indicator
+			delete(t.pending, pid)
+		}
+		// only packets with a payload advance the counter
+		if afc&0x1 != 0 {
+			cc, ok := t.counters[pid]
+			if ok {
+				cc = (cc + 1) & 0xf
+			} else {
+				cc = pkt[3] & 0xf
+			}
+			t.counters[pid] = cc
+			pkt[3] = pkt[3]&0xf0 | cc
+		}
+	}
+	t.all = false
+	return data
+}
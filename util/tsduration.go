@@ -0,0 +1,51 @@
+package util
+
+import "errors"
+
+// TSDuration returns the duration of an MPEG-TS clip in seconds, from the timestamps of its first elementary stream
+func TSDuration(data []byte) (float64, error) {
+	pid := -1
+	var first, last int64 // the lowest and highest pts, frames are stored in decoding order
+	frames := 0
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			return 0, errors.New("not an MPEG-TS clip")
+		}
+		if pkt[1]&0x40 == 0 { // only the starts of pes packets
+			continue
+		}
+		pos := 4
+		if pkt[3]&0x20 != 0 {
+			pos += 1 + int(pkt[4])
+		}
+		if pos+14 > tsPacketSize {
+			continue
+		}
+		pes := pkt[pos:]
+		// pes with a pts, psi tables don't start with a start code
+		if pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[7]&0x80 == 0 {
+			continue
+		}
+		current := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		if pid < 0 {
+			pid = current
+		} else if current != pid {
+			continue
+		}
+		h := pes[9:]
+		pts := int64(h[0]>>1&0x7)<<30 | int64(h[1])<<22 | int64(h[2]>>1)<<15 | int64(h[3])<<7 | int64(h[4]>>1)
+		if frames == 0 || pts < first {
+			first = pts
+		}
+		if frames == 0 || pts > last {
+			last = pts
+		}
+		frames++
+	}
+	if frames < 2 {
+		return 0, errors.New("the clip is too short or has no timestamps")
+	}
+	// the last frame lasts as long as an average one
+	return float64(last-first) / 90000 * float64(frames) / float64(frames-1), nil
+}